@@ -0,0 +1,72 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/saichler/l8types/go/testtypes"
+	"github.com/saichler/l8utils/go/utils/cache"
+)
+
+func TestCacheFetchAggregateRows(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+	for i := 1; i <= 6; i++ {
+		m := createModel(i)
+		m.MyInt32 = int32(i)
+		m.MyBool = i%2 == 0
+		c.Post(m, false)
+	}
+
+	q := createIQuery("select count(*),sum(MyInt32) from TestProto group by MyBool", res)
+	groupBy := q.GroupBy()[0]
+	sumAlias := q.Aggregates()[1].Alias
+
+	limited := createIQuery("select count(*),sum(MyInt32) from TestProto group by MyBool limit 1", res)
+	rows, err := c.FetchAggregate(limited, sumAlias+" desc")
+	if err != nil {
+		t.Fatalf("Failed to fetch the aggregate rows: %s", err.Error())
+	}
+	if len(rows) != 1 {
+		t.Fatalf("Expected the limit of 1 row, got %d", len(rows))
+	}
+	if flag, ok := rows[0].GroupBy[groupBy].(bool); !ok || !flag {
+		t.Errorf("Expected the group by value true as a bool, got %v", rows[0].GroupBy[groupBy])
+	}
+	if sum, _ := cache.ToFloat64(rows[0].Aggregates[sumAlias]); sum != 12 {
+		t.Errorf("Expected sum 12, got %v", rows[0].Aggregates[sumAlias])
+	}
+
+	c.SetAggregateResultMode(cache.AggregateRows)
+	values, metadata := c.Fetch(0, 1, q)
+	if len(values) != 1 {
+		t.Fatalf("Expected a page of 1 row from Fetch, got %d", len(values))
+	}
+	if _, ok := values[0].(*cache.AggregateRow); !ok {
+		t.Errorf("Expected Fetch to return aggregate rows")
+	}
+	if metadata == nil || metadata.KeyCount.Counts[cache.Total] != 2 {
+		t.Errorf("Expected a total of 2 rows in the metadata, got %v", metadata)
+	}
+	if values, _ = c.Fetch(0, 0, limited); len(values) != 1 {
+		t.Errorf("Expected the query limit of 1 row from Fetch, got %d", len(values))
+	}
+
+	failing := createIQuery("select count(*) from TestProto group by MyBool having "+groupBy+">true", res)
+	if values, metadata = c.Fetch(0, 0, failing); values != nil || metadata != nil {
+		t.Errorf("Expected a HAVING clause that cannot be evaluated to fail the fetch")
+	}
+}
@@ -0,0 +1,63 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/saichler/l8types/go/testtypes"
+	"github.com/saichler/l8utils/go/utils/cache"
+)
+
+func TestCacheRegisteredAggregateMatchesScan(t *testing.T) {
+	res := newResources()
+	gsql := "select count(*),sum(MyInt32),min(MyInt32),max(MyInt32) from TestProto group by MyBool"
+	maintained := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer maintained.Close()
+	scanned := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer scanned.Close()
+
+	for i := 1; i <= 10; i++ {
+		maintained.Post(createModel(i), false)
+		scanned.Post(createModel(i), false)
+	}
+	if err := maintained.RegisterAggregate(createIQuery(gsql, res)); err != nil {
+		t.Fatalf("Failed to register the aggregate: %s", err.Error())
+	}
+
+	mutate := func(c *cache.Cache) {
+		for i := 1; i <= 3; i++ {
+			c.Delete(createModel(i), false)
+		}
+		patch := createModel(5)
+		patch.MyInt32 = 1000
+		c.Patch(patch, false)
+		patch = createModel(6)
+		patch.MyBool = !createModel(6).MyBool
+		c.Put(patch, false)
+		c.Post(createModel(20), false)
+	}
+	mutate(maintained)
+	mutate(scanned)
+
+	_, expected := scanned.Fetch(0, 0, createIQuery(gsql, res))
+	_, actual := maintained.Fetch(0, 0, createIQuery(gsql, res))
+	if len(expected.KeyCount.Counts) == 0 {
+		t.Fatalf("Expected aggregate results")
+	}
+	if !reflect.DeepEqual(expected.KeyCount.Counts, actual.KeyCount.Counts) {
+		t.Errorf("Expected maintained aggregates %v to match the scan %v", actual.KeyCount.Counts, expected.KeyCount.Counts)
+	}
+}
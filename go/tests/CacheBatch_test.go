@@ -0,0 +1,120 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/saichler/l8types/go/testtypes"
+	"github.com/saichler/l8types/go/types/l8notify"
+	"github.com/saichler/l8utils/go/utils/cache"
+)
+
+func TestCacheBatchRollback(t *testing.T) {
+	res := newResources()
+	storage := newTestStorage(true)
+	c := cache.NewCache(&testtypes.TestProto{}, nil, storage, res)
+	defer c.Close()
+
+	for i := 1; i <= 5; i++ {
+		c.Post(createModel(i), false)
+	}
+
+	patch := createModel(1)
+	patch.MyBool = !createModel(1).MyBool
+	batch := cache.NewBatch().
+		Post(createModel(6)).
+		Patch(patch).
+		Delete(createModel(2)).
+		Delete(createModel(99))
+
+	if _, _, err := c.Batch(batch, false); err == nil {
+		t.Fatal("Expected batch to fail on deleting a missing element")
+	}
+	if c.Size() != 5 {
+		t.Errorf("Expected 5 elements after rollback, got %d", c.Size())
+	}
+	if _, err := c.Get(createModel(6)); err == nil {
+		t.Error("Expected posted element to be rolled back")
+	}
+	if _, err := c.Get(createModel(2)); err != nil {
+		t.Error("Expected deleted element to be restored")
+	}
+	item, _ := c.Get(createModel(1))
+	if item.(*testtypes.TestProto).MyBool != createModel(1).MyBool {
+		t.Error("Expected patched element to be rolled back")
+	}
+}
+
+func TestCacheBatchNotification(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+	c.SetNotificationsFor("TestService", 1)
+
+	for i := 1; i <= 5; i++ {
+		c.Post(createModel(i), false)
+	}
+
+	batch := cache.NewBatch().Post(createModel(6)).Delete(createModel(1))
+	sets, _, err := c.Batch(batch, true)
+	if err != nil {
+		t.Fatalf("Expected batch to succeed, got %s", err.Error())
+	}
+	if c.Size() != 5 {
+		t.Errorf("Expected 5 elements after batch, got %d", c.Size())
+	}
+	if len(sets) != 2 {
+		t.Fatalf("Expected 2 notification sets, got %d", len(sets))
+	}
+	if sets[0].Type != l8notify.L8NotificationType_Post || sets[1].Type != l8notify.L8NotificationType_Delete {
+		t.Errorf("Expected a Post and a Delete, got %v and %v", sets[0].Type, sets[1].Type)
+	}
+	if sets[1].Sequence != sets[0].Sequence+1 {
+		t.Errorf("Expected consecutive sequences, got %d and %d", sets[0].Sequence, sets[1].Sequence)
+	}
+}
+
+func TestCacheBatchRollbackKeepsRevisionsAndHistory(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+
+	if err := c.SetHistory(10, 0); err != nil {
+		t.Fatal(err)
+	}
+	c.Post(createModel(1), false)
+	_, revision, _ := c.GetWithRevision(createModel(1))
+
+	patch := createModel(1)
+	patch.MyBool = !createModel(1).MyBool
+	batch := cache.NewBatch().Patch(patch).Post(createModel(2)).Delete(createModel(99))
+	if _, _, err := c.Batch(batch, false); err == nil {
+		t.Fatal("Expected batch to fail on deleting a missing element")
+	}
+
+	if _, after, _ := c.GetWithRevision(createModel(1)); after != revision {
+		t.Errorf("Expected revision %d after rollback, got %d", revision, after)
+	}
+	history, err := c.History(createModel(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Deleted {
+		t.Errorf("Expected only the original revision in the history, got %d entries", len(history))
+	}
+	if history, _ = c.History(createModel(2)); len(history) != 0 {
+		t.Errorf("Expected no history of the rolled back post, got %d entries", len(history))
+	}
+}
@@ -0,0 +1,146 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/saichler/l8types/go/testtypes"
+	"github.com/saichler/l8types/go/types/l8notify"
+	"github.com/saichler/l8utils/go/utils/cache"
+	"github.com/saichler/l8utils/go/utils/notify"
+)
+
+func TestCacheCoalesceClientNotifications(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+	c.SetNotificationsFor("TestService", 1)
+	c.RegisterSubscription("aaa-1", 1, "select * from TestProto")
+
+	mtx := &sync.Mutex{}
+	delivered := make([]*l8notify.L8NotificationSet, 0)
+	c.CoalesceClientNotifications(100*time.Millisecond, false, func(sets []*l8notify.L8NotificationSet) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		delivered = append(delivered, sets...)
+	})
+
+	c.Post(createModel(1), true)
+	for i := 0; i < 10; i++ {
+		item := createModel(1)
+		item.MyInt32 = int32(i + 100)
+		_, cn, _ := c.Patch(item, true)
+		if cn != nil {
+			t.Fatalf("Expected the client notification to be held for the window")
+		}
+	}
+	c.Post(createModel(2), true)
+	c.Delete(createModel(2), true)
+
+	time.Sleep(300 * time.Millisecond)
+	mtx.Lock()
+	defer mtx.Unlock()
+	if len(delivered) != 1 {
+		t.Fatalf("Expected 1 coalesced notification, got %d", len(delivered))
+	}
+	if delivered[0].Type != l8notify.L8NotificationType_Post {
+		t.Errorf("Expected the coalesced notification to remain a Post, got %s", delivered[0].Type.String())
+	}
+	item, _, err := notify.ItemOf(delivered[0], res, false)
+	if err != nil {
+		t.Fatalf("Failed to extract the item: %s", err.Error())
+	}
+	if item.(*testtypes.TestProto).MyInt32 != 109 {
+		t.Errorf("Expected the latest element, got %d", item.(*testtypes.TestProto).MyInt32)
+	}
+}
+
+func TestCacheCoalesceBatchKeys(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+	c.SetNotificationsFor("TestService", 1)
+	c.RegisterSubscription("aaa-1", 1, "select * from TestProto")
+
+	mtx := &sync.Mutex{}
+	delivered := make([][]*l8notify.L8NotificationSet, 0)
+	c.CoalesceClientNotifications(100*time.Millisecond, true, func(sets []*l8notify.L8NotificationSet) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		delivered = append(delivered, sets)
+	})
+
+	for i := 1; i <= 5; i++ {
+		c.Post(createModel(i), true)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	mtx.Lock()
+	defer mtx.Unlock()
+	if len(delivered) != 1 || len(delivered[0]) != 5 {
+		t.Fatalf("Expected the 5 notifications in a single delivery, got %d deliveries", len(delivered))
+	}
+	for i, cn := range delivered[0] {
+		if pk, _, _ := c.KeysFor(createModel(i + 1)); cn.ModelKey != pk {
+			t.Errorf("Expected notification %d to be of element %d, got %s", i, i+1, cn.ModelKey)
+		}
+		if !cn.AaaIds["aaa-1"] {
+			t.Errorf("Expected notification %d to be addressed to aaa-1", i)
+		}
+	}
+}
+
+func TestCacheCoalesceKeepsBatchOrder(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+	c.SetNotificationsFor("TestService", 1)
+	c.RegisterSubscription("aaa-1", 1, "select * from TestProto")
+
+	mtx := &sync.Mutex{}
+	delivered := make([][]*l8notify.L8NotificationSet, 0)
+	c.CoalesceClientNotifications(100*time.Millisecond, false, func(sets []*l8notify.L8NotificationSet) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		delivered = append(delivered, sets)
+	})
+
+	c.Post(createModel(1), true)
+	if _, _, err := c.Batch(cache.NewBatch().Post(createModel(2)).Post(createModel(3)), true); err != nil {
+		t.Fatal(err)
+	}
+	// changes the element 1 pending before the batch, it must not move before the batch
+	item := createModel(1)
+	item.MyBool = !item.MyBool
+	c.Put(item, true)
+
+	time.Sleep(300 * time.Millisecond)
+	mtx.Lock()
+	defer mtx.Unlock()
+	if len(delivered) != 3 {
+		t.Fatalf("Expected 3 deliveries, got %d", len(delivered))
+	}
+	if len(delivered[0]) != 1 || delivered[0][0].Type != l8notify.L8NotificationType_Post {
+		t.Errorf("Expected the Post of element 1 first")
+	}
+	if len(delivered[1]) != 2 {
+		t.Errorf("Expected the 2 notifications of the batch second, got %d", len(delivered[1]))
+	}
+	if len(delivered[2]) != 1 || delivered[2][0].Type != l8notify.L8NotificationType_Put {
+		t.Errorf("Expected the Put of element 1 after the batch")
+	}
+}
@@ -0,0 +1,204 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/saichler/l8types/go/testtypes"
+	"github.com/saichler/l8utils/go/utils/cache"
+)
+
+func TestCacheConcurrentGetPutFetch(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+	for i := 1; i <= 100; i++ {
+		c.Post(createModel(i), false)
+	}
+
+	wg := &sync.WaitGroup{}
+	for w := 0; w < 4; w++ {
+		wg.Add(3)
+		go func(w int) {
+			defer wg.Done()
+			for i := 1; i <= 100; i++ {
+				c.Post(createModel(1000+w*100+i), false)
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 1; i <= 100; i++ {
+				if item, err := c.Get(createModel(i)); err != nil || item == nil {
+					t.Errorf("Expected element %d during concurrent writes", i)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			q := createIQuery("select * from TestProto where MyInt32<101", res)
+			for i := 0; i < 20; i++ {
+				if elems, _ := c.Fetch(0, 0, q); len(elems) != 100 {
+					t.Errorf("Expected 100 fetched elements, got %d", len(elems))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if c.Size() != 500 {
+		t.Errorf("Expected 500 elements, got %d", c.Size())
+	}
+}
+
+// blockingStorage is safe for concurrent use and blocks the Put of the element whose
+// MyInt32 is blocked, until release is closed.
+type blockingStorage struct {
+	*testStorage
+	mtx     sync.Mutex
+	blocked int32
+	entered chan bool
+	release chan bool
+}
+
+func (s *blockingStorage) ConcurrentSafe() bool {
+	return true
+}
+
+func (s *blockingStorage) Get(key string) (interface{}, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.testStorage.Get(key)
+}
+
+func (s *blockingStorage) Put(key string, value interface{}) error {
+	if value.(*testtypes.TestProto).MyInt32 == s.blocked {
+		s.entered <- true
+		<-s.release
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.testStorage.Put(key, value)
+}
+
+func (s *blockingStorage) Delete(key string) (interface{}, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.testStorage.Delete(key)
+}
+
+func (s *blockingStorage) Collect(f func(interface{}) (bool, interface{})) map[string]interface{} {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.testStorage.Collect(f)
+}
+
+// overlapStorage counts the store writes that overlapped another one.
+type overlapStorage struct {
+	*testStorage
+	writing  atomic.Int32
+	overlaps atomic.Int32
+}
+
+func (s *overlapStorage) Put(key string, value interface{}) error {
+	if s.writing.Add(1) > 1 {
+		s.overlaps.Add(1)
+	}
+	defer s.writing.Add(-1)
+	time.Sleep(time.Millisecond)
+	return s.testStorage.Put(key, value)
+}
+
+func TestCacheSerializesStoreWrites(t *testing.T) {
+	res := newResources()
+	store := &overlapStorage{testStorage: newTestStorage(true)}
+	c := cache.NewCache(&testtypes.TestProto{}, nil, store, res)
+	defer c.Close()
+
+	wg := &sync.WaitGroup{}
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 1; i <= 20; i++ {
+				c.Post(createModel(w*100+i), false)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if store.overlaps.Load() != 0 {
+		t.Errorf("Expected the store writes to be serialized, %d overlapped", store.overlaps.Load())
+	}
+	if c.Size() != 160 {
+		t.Errorf("Expected 160 elements, got %d", c.Size())
+	}
+}
+
+func TestCacheWritesOfDifferentShardsRunInParallel(t *testing.T) {
+	res := newResources()
+	store := &blockingStorage{testStorage: newTestStorage(true), blocked: 1,
+		entered: make(chan bool, 1), release: make(chan bool)}
+	c := cache.NewCache(&testtypes.TestProto{}, nil, store, res)
+	defer c.Close()
+
+	blocked := make(chan bool)
+	go func() {
+		c.Post(createModel(1), false)
+		close(blocked)
+	}()
+	<-store.entered
+
+	// while the write of element 1 is blocked in the store, the writes of the elements
+	// of the other shards complete
+	done := make(chan int, 16)
+	for i := 2; i <= 17; i++ {
+		go func(i int) {
+			c.Post(createModel(i), false)
+			done <- i
+		}(i)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		close(store.release)
+		t.Fatal("Expected a write of another shard to complete while a write is blocked")
+	}
+	q := createIQuery("select * from TestProto where MyInt32>1", res)
+	if elems, _ := c.Fetch(0, 0, q); len(elems) == 0 {
+		t.Error("Expected the completed writes to be fetched while a write is blocked")
+	}
+	select {
+	case <-blocked:
+		t.Fatal("Expected the write of element 1 to still be blocked")
+	default:
+	}
+
+	close(store.release)
+	<-blocked
+	for i := 0; i < 15; i++ {
+		<-done
+	}
+	if c.Size() != 17 {
+		t.Errorf("Expected 17 elements, got %d", c.Size())
+	}
+	if item, err := c.Get(createModel(1)); err != nil || item == nil {
+		t.Error("Expected the blocked element after its write completed")
+	}
+}
@@ -0,0 +1,128 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/saichler/l8types/go/testtypes"
+	"github.com/saichler/l8utils/go/utils/cache"
+)
+
+func TestCacheCopyOnWrite(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+	for i := 1; i <= 10; i++ {
+		c.Post(createModel(i), false)
+	}
+	if err := c.SetCopyOnWrite(true); err != nil {
+		t.Fatalf("Failed to enable copy on write: %s", err.Error())
+	}
+
+	first, _ := c.Get(createModel(1))
+	second, _ := c.Get(createModel(1))
+	if first != second {
+		t.Fatal("Expected Get to return the shared element in copy on write mode")
+	}
+
+	patch := createModel(1)
+	patch.MyBool = !first.(*testtypes.TestProto).MyBool
+	before := first.(*testtypes.TestProto).MyBool
+	if _, _, err := c.Patch(patch, false); err != nil {
+		t.Fatalf("Failed to patch: %s", err.Error())
+	}
+	if first.(*testtypes.TestProto).MyBool != before {
+		t.Error("Expected the patch not to change the element held by a reader")
+	}
+	patched, _ := c.Get(createModel(1))
+	if patched.(*testtypes.TestProto).MyBool != patch.MyBool {
+		t.Error("Expected Get to return the new version of the element")
+	}
+
+	elems, _ := c.Fetch(0, 0, createIQuery("select * from TestProto", res))
+	shared := false
+	for _, elem := range elems {
+		if elem == patched {
+			shared = true
+		}
+	}
+	if !shared {
+		t.Error("Expected Fetch to return the shared elements in copy on write mode")
+	}
+
+	if err := c.SetCopyOnWrite(false); err == nil {
+		t.Error("Expected disabling copy on write to fail once enabled")
+	}
+	if !c.CopyOnWrite() {
+		t.Error("Expected copy on write to stay enabled")
+	}
+	if again, _ := c.Get(createModel(1)); again != patched {
+		t.Error("Expected Get to still return the shared element")
+	}
+}
+
+func benchmarkCacheFetchPage(b *testing.B, copyOnWrite bool) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+	for i := 1; i <= 1000; i++ {
+		c.Post(createModel(i), false)
+	}
+	c.SetCopyOnWrite(copyOnWrite)
+	q := createIQuery("select * from TestProto", res)
+	c.Fetch(0, 1000, q)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Fetch(0, 1000, q)
+	}
+}
+
+func BenchmarkCacheFetchPageClone(b *testing.B) {
+	benchmarkCacheFetchPage(b, false)
+}
+
+func BenchmarkCacheFetchPageCopyOnWrite(b *testing.B) {
+	benchmarkCacheFetchPage(b, true)
+}
+
+func benchmarkCacheGet(b *testing.B, copyOnWrite bool) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+	for i := 1; i <= 1000; i++ {
+		c.Post(createModel(i), false)
+	}
+	c.SetCopyOnWrite(copyOnWrite)
+	keys := make([]*testtypes.TestProto, 1000)
+	for i := range keys {
+		keys[i] = createModel(i + 1)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Get(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkCacheGetClone(b *testing.B) {
+	benchmarkCacheGet(b, false)
+}
+
+func BenchmarkCacheGetCopyOnWrite(b *testing.B) {
+	benchmarkCacheGet(b, true)
+}
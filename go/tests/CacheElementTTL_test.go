@@ -0,0 +1,79 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/saichler/l8types/go/testtypes"
+	"github.com/saichler/l8types/go/types/l8notify"
+	"github.com/saichler/l8utils/go/utils/cache"
+)
+
+func TestCacheElementTTLExpiry(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+	c.SetNotificationsFor("TestService", 1)
+
+	expired := make([]*l8notify.L8NotificationSet, 0)
+	c.OnElementExpired(func(delta, client *l8notify.L8NotificationSet) {
+		expired = append(expired, delta)
+	})
+
+	c.Post(createModel(1), false)
+	c.PostWithTTL(createModel(2), time.Millisecond*10, false)
+
+	time.Sleep(time.Millisecond * 50)
+	removed := c.ExpireElementsNow()
+	if removed != 1 {
+		t.Fatalf("Expected 1 expired element, got %d", removed)
+	}
+	if c.Size() != 1 {
+		t.Errorf("Expected 1 element left, got %d", c.Size())
+	}
+	if len(expired) != 1 {
+		t.Fatalf("Expected 1 expiry notification, got %d", len(expired))
+	}
+	if expired[0].Type != l8notify.L8NotificationType_Delete {
+		t.Errorf("Expected Delete notification, got %v", expired[0].Type)
+	}
+	if _, err := c.Get(createModel(2)); err == nil {
+		t.Error("Expected expired element to be gone")
+	}
+}
+
+func TestCacheDefaultElementTTL(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+
+	c.SetElementTTL(time.Millisecond * 10)
+	for i := 1; i <= 3; i++ {
+		c.Post(createModel(i), false)
+	}
+	// Put refreshes the expiry of the replaced element
+	time.Sleep(time.Millisecond * 50)
+	c.SetElementTTL(time.Hour)
+	c.Put(createModel(3), false)
+
+	removed := c.ExpireElementsNow()
+	if removed != 2 {
+		t.Errorf("Expected 2 expired elements, got %d", removed)
+	}
+	if c.Size() != 1 {
+		t.Errorf("Expected 1 element left, got %d", c.Size())
+	}
+}
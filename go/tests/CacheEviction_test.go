@@ -0,0 +1,181 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/saichler/l8types/go/testtypes"
+	"github.com/saichler/l8utils/go/utils/cache"
+)
+
+func TestCacheEvictionRequiresStore(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+
+	if err := c.SetEviction(cache.EvictLRU, 10, 0); err == nil {
+		t.Fatal("Expected error when setting eviction without a store")
+	}
+}
+
+// countingStorage counts the element reads of the store.
+type countingStorage struct {
+	*queryStorage
+	gets int
+}
+
+func (s *countingStorage) Get(key string) (interface{}, error) {
+	s.gets++
+	return s.queryStorage.Get(key)
+}
+
+func testCacheEviction(t *testing.T, policy cache.EvictionPolicy) {
+	res := newResources()
+	storage := &queryStorage{testStorage: newTestStorage(true)}
+	c := cache.NewCache(&testtypes.TestProto{}, nil, storage, res)
+	defer c.Close()
+
+	for i := 1; i <= 20; i++ {
+		c.Post(createModel(i), false)
+	}
+	if err := c.SetEviction(policy, 5, 0); err != nil {
+		t.Fatalf("Expected eviction to be set, got %s", err.Error())
+	}
+
+	if c.ResidentSize() != 5 {
+		t.Errorf("Expected 5 resident elements, got %d", c.ResidentSize())
+	}
+	if c.Size() != 20 {
+		t.Errorf("Expected size 20, got %d", c.Size())
+	}
+
+	// Every element is still reachable, evicted ones are reloaded from the store
+	for i := 1; i <= 20; i++ {
+		item, err := c.Get(createModel(i))
+		if err != nil || item == nil {
+			t.Fatalf("Expected element %d to be loaded, got error %v", i, err)
+		}
+	}
+	if c.ResidentSize() > 5 {
+		t.Errorf("Expected at most 5 resident elements, got %d", c.ResidentSize())
+	}
+
+	// the evicted elements are queried by the store
+	elems, _ := c.Fetch(0, 100, createIQuery("select * from TestProto", res))
+	if len(elems) != 20 {
+		t.Errorf("Expected fetch to return 20 elements, got %d", len(elems))
+	}
+
+	c.Delete(createModel(1), false)
+	if c.Size() != 19 {
+		t.Errorf("Expected size 19 after delete, got %d", c.Size())
+	}
+
+	if err := c.SetEviction(cache.EvictNone, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if c.ResidentSize() != 19 {
+		t.Errorf("Expected all 19 elements resident after removing the bound, got %d", c.ResidentSize())
+	}
+}
+
+func TestCacheEvictionLRU(t *testing.T) {
+	testCacheEviction(t, cache.EvictLRU)
+}
+
+func TestCacheEvictionLFU(t *testing.T) {
+	testCacheEviction(t, cache.EvictLFU)
+}
+
+func TestCacheEvictionLFUBound(t *testing.T) {
+	res := newResources()
+	storage := newTestStorage(true)
+	c := cache.NewCache(&testtypes.TestProto{}, nil, storage, res)
+	defer c.Close()
+
+	if err := c.SetEviction(cache.EvictLFU, 5, 0); err != nil {
+		t.Fatal(err)
+	}
+	// The new element has the lowest count, the next least used one is evicted instead
+	for i := 1; i <= 20; i++ {
+		c.Post(createModel(i), false)
+		if c.ResidentSize() > 5 {
+			t.Fatalf("Expected at most 5 resident elements after post %d, got %d", i, c.ResidentSize())
+		}
+		if i > 1 {
+			c.Get(createModel(i - 1))
+		}
+	}
+	for i := 1; i <= 20; i++ {
+		if item, err := c.Get(createModel(i)); err != nil || item == nil {
+			t.Fatalf("Expected element %d to be loaded, got error %v", i, err)
+		}
+		if c.ResidentSize() > 5 {
+			t.Fatalf("Expected at most 5 resident elements after get %d, got %d", i, c.ResidentSize())
+		}
+	}
+	if c.Size() != 20 {
+		t.Errorf("Expected size 20, got %d", c.Size())
+	}
+}
+
+func TestCacheEvictionQueriesResidentElements(t *testing.T) {
+	res := newResources()
+	storage := newTestStorage(true)
+	c := cache.NewCache(&testtypes.TestProto{}, nil, storage, res)
+	defer c.Close()
+
+	for i := 1; i <= 20; i++ {
+		c.Post(createModel(i), false)
+	}
+	if err := c.SetEviction(cache.EvictLRU, 5, 0); err != nil {
+		t.Fatal(err)
+	}
+	// a store that cannot execute queries is not scanned for the evicted elements
+	elems, _ := c.Fetch(0, 100, createIQuery("select * from TestProto", res))
+	if len(elems) != 5 {
+		t.Errorf("Expected fetch to return the 5 resident elements, got %d", len(elems))
+	}
+	if c.Size() != 20 {
+		t.Errorf("Expected size 20, got %d", c.Size())
+	}
+}
+
+func TestCacheEvictionLFUKeepsNewElements(t *testing.T) {
+	res := newResources()
+	storage := &countingStorage{queryStorage: &queryStorage{testStorage: newTestStorage(true)}}
+	c := cache.NewCache(&testtypes.TestProto{}, nil, storage, res)
+	defer c.Close()
+
+	if err := c.SetEviction(cache.EvictLFU, 5, 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		c.Post(createModel(i), false)
+		c.Get(createModel(i))
+	}
+	// the cache ages with every eviction, so new elements replace the ones that were
+	// used long ago instead of evicting each other
+	for i := 6; i <= 20; i++ {
+		c.Post(createModel(i), false)
+	}
+	gets := storage.gets
+	if item, err := c.Get(createModel(19)); err != nil || item == nil {
+		t.Fatal("Expected element 19 to be in the cache")
+	}
+	if storage.gets != gets {
+		t.Error("Expected element 19 to still be resident after the next post")
+	}
+}
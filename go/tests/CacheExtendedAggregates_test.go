@@ -0,0 +1,144 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/l8types/go/testtypes"
+	"github.com/saichler/l8types/go/types/l8api"
+	"github.com/saichler/l8utils/go/utils/cache"
+)
+
+// extendedQuery adds aggregate functions the query language does not compute.
+type extendedQuery struct {
+	ifs.IQuery
+	extended []*l8api.L8AggregateFunction
+}
+
+func (this *extendedQuery) Aggregates() []*l8api.L8AggregateFunction {
+	return append(this.IQuery.Aggregates(), this.extended...)
+}
+
+func TestCacheExtendedAggregates(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+	for i := 1; i <= 100; i++ {
+		m := createModel(i)
+		m.MyInt32 = int32(i)
+		m.MyBool = i%10 == 0
+		c.Post(m, false)
+	}
+
+	q := &extendedQuery{
+		IQuery: createIQuery("select count(*) from TestProto", res),
+		extended: []*l8api.L8AggregateFunction{
+			{Function: "count_distinct", Property: "MyBool", Alias: "flags"},
+			{Function: "p95", Property: "MyInt32", Alias: "p95"},
+			{Function: "stddev", Property: "MyInt32", Alias: "sd"},
+			{Function: "histogram", Property: "MyInt32", Alias: "hist"},
+		},
+	}
+	c.SetHistogramBounds("hist", []float64{10, 50})
+
+	rows, err := c.FetchAggregate(q, "")
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("Expected a single row, got %d", len(rows))
+	}
+	values := rows[0].Aggregates
+	if values["flags"] != float64(2) {
+		t.Errorf("Expected 2 distinct flags, got %v", values["flags"])
+	}
+	if p95, _ := cache.ToFloat64(values["p95"]); p95 < 95 || p95 > 96 {
+		t.Errorf("Expected p95 between 95 and 96, got %v", values["p95"])
+	}
+	if sd, _ := cache.ToFloat64(values["sd"]); sd < 28.8 || sd > 28.9 {
+		t.Errorf("Expected standard deviation of about 28.87, got %v", values["sd"])
+	}
+	hist, ok := values["hist"].([]cache.HistogramBucket)
+	if !ok || len(hist) != 3 || hist[0].Count != 10 || hist[1].Count != 40 || hist[2].Count != 50 {
+		t.Errorf("Expected histogram buckets of 10, 40 and 50, got %v", values["hist"])
+	}
+
+	_, metadata := c.Fetch(0, 0, q)
+	if metadata.KeyCount.Counts["hist[+Inf]"] != 50 {
+		t.Errorf("Expected the packed +Inf bucket of 50, got %v", metadata.KeyCount.Counts["hist[+Inf]"])
+	}
+}
+
+// aliasOf returns the alias the query parser gave to the aggregate function.
+func aliasOf(t *testing.T, q ifs.IQuery, function string) string {
+	for _, agg := range q.Aggregates() {
+		if strings.EqualFold(agg.Function, function) {
+			return agg.Alias
+		}
+	}
+	t.Fatalf("Expected the parsed query to have a %s aggregate", function)
+	return ""
+}
+
+func TestCacheExtendedAggregatesParsed(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+	for i := 1; i <= 100; i++ {
+		m := createModel(i)
+		m.MyInt32 = int32(i)
+		m.MyBool = i%10 == 0
+		c.Post(m, false)
+	}
+
+	gsql := "select count(*),p95(MyInt32),stddev(MyInt32) from TestProto group by MyBool"
+	q := createIQuery(gsql, res)
+	groupBy := q.GroupBy()[0]
+	p95 := aliasOf(t, q, "p95")
+	sd := aliasOf(t, q, "stddev")
+
+	rows, err := c.FetchAggregate(q, groupBy)
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %d, error %v", len(rows), err)
+	}
+	// the flagged group is 10, 20 ... 100
+	if v, _ := cache.ToFloat64(rows[1].Aggregates[p95]); v != 95.5 {
+		t.Errorf("Expected p95 of 95.5 for the flagged group, got %v", rows[1].Aggregates[p95])
+	}
+	if v, _ := cache.ToFloat64(rows[0].Aggregates[p95]); v < 94.5 || v > 94.6 {
+		t.Errorf("Expected p95 of about 94.55 for the other group, got %v", rows[0].Aggregates[p95])
+	}
+	if _, ok := rows[0].Aggregates[sd]; !ok {
+		t.Error("Expected the standard deviation to be computed")
+	}
+
+	tests := []struct {
+		having string
+		rows   int
+	}{
+		{p95 + ">95", 1},
+		{p95 + ">100", 0},
+		{sd + ">0", 2},
+		{p95 + ">95 and " + groupBy + "=true", 1},
+	}
+	for _, test := range tests {
+		rows, err := c.FetchAggregate(createIQuery(gsql+" having "+test.having, res), "")
+		if err != nil {
+			t.Fatalf("Failed to evaluate HAVING %s: %s", test.having, err.Error())
+		}
+		if len(rows) != test.rows {
+			t.Errorf("Expected %d rows for HAVING %s, got %d", test.rows, test.having, len(rows))
+		}
+	}
+}
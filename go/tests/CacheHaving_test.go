@@ -0,0 +1,117 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/saichler/l8types/go/testtypes"
+	"github.com/saichler/l8utils/go/utils/cache"
+)
+
+func TestCacheHavingComparisons(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+	for i := 1; i <= 6; i++ {
+		m := createModel(i)
+		m.MyBool = i%2 == 0
+		c.Post(m, false)
+	}
+
+	gsql := "select count(*),max(MyInt32),min(MyInt32) from TestProto group by MyBool"
+	base := createIQuery(gsql, res)
+	groupBy := base.GroupBy()[0]
+	maxAlias := base.Aggregates()[1].Alias
+	minAlias := base.Aggregates()[2].Alias
+
+	tests := []struct {
+		having string
+		rows   int
+	}{
+		{groupBy + "=true", 1},
+		{groupBy + "!=true", 1},
+		{maxAlias + ">" + minAlias, 2},
+		{maxAlias + "<" + minAlias, 0},
+	}
+	for _, test := range tests {
+		rows, err := c.FetchAggregate(createIQuery(gsql+" having "+test.having, res), "")
+		if err != nil {
+			t.Fatalf("Failed to evaluate HAVING %s: %s", test.having, err.Error())
+		}
+		if len(rows) != test.rows {
+			t.Errorf("Expected %d rows for HAVING %s, got %d", test.rows, test.having, len(rows))
+		}
+	}
+
+	_, err := c.FetchAggregate(createIQuery(gsql+" having "+groupBy+">true", res), "")
+	if err == nil {
+		t.Errorf("Expected an error for ordering booleans")
+	}
+}
+
+func TestCacheHavingInAndLike(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+	for i := 1; i <= 6; i++ {
+		m := createModel(i)
+		m.MyBool = i%2 == 0
+		if i <= 4 {
+			m.MyString = "alpha-" + strconv.Itoa(i)
+		} else {
+			m.MyString = "beta-" + strconv.Itoa(i)
+		}
+		c.Post(m, false)
+	}
+
+	byBool := "select count(*) from TestProto group by MyBool"
+	base := createIQuery(byBool, res)
+	boolGroup := base.GroupBy()[0]
+	countAlias := base.Aggregates()[0].Alias
+
+	byString := "select count(*) from TestProto group by MyString"
+	stringGroup := createIQuery(byString, res).GroupBy()[0]
+
+	tests := []struct {
+		gsql   string
+		having string
+		rows   int
+	}{
+		{byBool, countAlias + " in (3)", 2},
+		{byBool, countAlias + " in (1,2)", 0},
+		{byBool, countAlias + " not in (1,2)", 2},
+		{byBool, boolGroup + " in (true)", 1},
+		{byBool, countAlias + ">3", 0},
+		{byString, stringGroup + " like 'alpha%'", 4},
+		{byString, stringGroup + " like 'beta-_'", 2},
+		{byString, stringGroup + " not like 'alpha%'", 2},
+		{byString, stringGroup + " like 'gamma%'", 0},
+	}
+	for _, test := range tests {
+		rows, err := c.FetchAggregate(createIQuery(test.gsql+" having "+test.having, res), "")
+		if err != nil {
+			t.Fatalf("Failed to evaluate HAVING %s: %s", test.having, err.Error())
+		}
+		if len(rows) != test.rows {
+			t.Errorf("Expected %d rows for HAVING %s, got %d", test.rows, test.having, len(rows))
+		}
+	}
+
+	_, err := c.FetchAggregate(createIQuery(byBool+" having "+countAlias+" like '3%'", res), "")
+	if err == nil {
+		t.Errorf("Expected an error for LIKE on a number")
+	}
+}
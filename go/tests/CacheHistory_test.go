@@ -0,0 +1,95 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/saichler/l8types/go/testtypes"
+	"github.com/saichler/l8utils/go/utils/cache"
+)
+
+func TestCacheHistoryAndGetAsOf(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+
+	if err := c.SetHistory(10, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	m := createModel(1)
+	m.MyBool = false
+	c.Post(m, false)
+	time.Sleep(time.Millisecond * 2)
+	beforeChange := time.Now()
+	time.Sleep(time.Millisecond * 2)
+
+	m = createModel(1)
+	m.MyBool = true
+	c.Put(m, false)
+	c.Delete(m, false)
+
+	history, err := c.History(createModel(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("Expected 3 revisions, got %d", len(history))
+	}
+	if !history[2].Deleted {
+		t.Error("Expected the last revision to be the deletion")
+	}
+
+	old, err := c.GetAsOf(createModel(1), beforeChange)
+	if err != nil {
+		t.Fatalf("Expected element as of before the change, got %s", err.Error())
+	}
+	if old.(*testtypes.TestProto).MyBool != false {
+		t.Error("Expected the element value from before the change")
+	}
+	if _, err = c.GetAsOf(createModel(1), time.Now()); err == nil {
+		t.Error("Expected no element after its deletion")
+	}
+}
+
+func TestCacheHistoryDroppedOnDeleteWithoutMaxAge(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+
+	if err := c.SetHistory(5, 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 10; i++ {
+		c.Post(createModel(i), false)
+		c.Put(createModel(i), false)
+		c.Delete(createModel(i), false)
+	}
+	for i := 1; i <= 10; i++ {
+		history, err := c.History(createModel(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 0 {
+			t.Fatalf("Expected the history of deleted element %d to be dropped, got %d entries", i, len(history))
+		}
+	}
+
+	c.Post(createModel(1), false)
+	if history, _ := c.History(createModel(1)); len(history) != 1 {
+		t.Errorf("Expected the re-added element to start a new history, got %d entries", len(history))
+	}
+}
@@ -0,0 +1,133 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/saichler/l8types/go/testtypes"
+	"github.com/saichler/l8utils/go/utils/cache"
+)
+
+func TestCacheAddIndex(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+
+	if err := c.AddIndex("MyInt32"); err != nil {
+		t.Fatalf("Expected index to be added, got %s", err.Error())
+	}
+	if err := c.AddIndex("NoSuchField"); err == nil {
+		t.Fatal("Expected error for unknown property path")
+	}
+	indexes := c.Indexes()
+	if len(indexes) != 1 || indexes[0] != "MyInt32" {
+		t.Fatalf("Expected [MyInt32], got %v", indexes)
+	}
+}
+
+func TestCacheIndexedFetchMatchesScan(t *testing.T) {
+	res := newResources()
+	indexed := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer indexed.Close()
+	plain := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer plain.Close()
+
+	if err := indexed.AddIndex("MyInt32"); err != nil {
+		t.Fatal(err)
+	}
+	models := make([]*testtypes.TestProto, 0)
+	for i := 1; i <= 20; i++ {
+		m := createModel(i)
+		models = append(models, m)
+		indexed.Post(m, false)
+		plain.Post(m, false)
+	}
+
+	queries := []string{
+		fmt.Sprintf("select * from TestProto where MyInt32=%d", models[4].MyInt32),
+		fmt.Sprintf("select * from TestProto where MyInt32>%d", models[9].MyInt32),
+		fmt.Sprintf("select * from TestProto where MyInt32<=%d", models[3].MyInt32),
+		fmt.Sprintf("select * from TestProto where MyInt32=%d or MyInt32=%d", models[1].MyInt32, models[7].MyInt32),
+		"select * from TestProto where MyString=*",
+	}
+	for _, text := range queries {
+		i, _ := indexed.Fetch(0, 100, createIQuery(text, res))
+		p, _ := plain.Fetch(0, 100, createIQuery(text, res))
+		if len(i) != len(p) {
+			t.Errorf("%s: indexed fetch returned %d, scan returned %d", text, len(i), len(p))
+		}
+	}
+}
+
+func TestCacheIndexMaintainedOnPatchAndDelete(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+
+	if err := c.AddIndex("MyBool"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		m := createModel(i)
+		m.MyBool = false
+		c.Post(m, false)
+	}
+
+	patch := createModel(3)
+	patch.MyBool = true
+	c.Patch(patch, false)
+
+	elems, _ := c.Fetch(0, 100, createIQuery("select * from TestProto where MyBool=true", res))
+	if len(elems) != 1 {
+		t.Fatalf("Expected 1 element after patch, got %d", len(elems))
+	}
+
+	c.Delete(createModel(3), false)
+	elems, _ = c.Fetch(0, 100, createIQuery("select * from TestProto where MyBool=true", res))
+	if len(elems) != 0 {
+		t.Fatalf("Expected 0 elements after delete, got %d", len(elems))
+	}
+}
+
+func TestCacheIndexNarrowsFetch(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+
+	if err := c.AddIndex("MyInt32"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 20; i++ {
+		c.Post(createModel(i), false)
+	}
+
+	elems, _ := c.Fetch(0, 100, createIQuery("select * from TestProto where MyString=*", res))
+	if len(elems) != 20 {
+		t.Fatalf("Expected 20 elements, got %d", len(elems))
+	}
+	if n := c.IndexedFetches(); n != 0 {
+		t.Fatalf("Expected no indexed fetch for a non indexed property, got %d", n)
+	}
+
+	text := fmt.Sprintf("select * from TestProto where MyInt32=%d", createModel(5).MyInt32)
+	elems, _ = c.Fetch(0, 100, createIQuery(text, res))
+	if len(elems) != 1 {
+		t.Fatalf("Expected 1 element, got %d", len(elems))
+	}
+	if n := c.IndexedFetches(); n != 1 {
+		t.Fatalf("Expected the fetch to be narrowed by the index, got %d indexed fetches", n)
+	}
+}
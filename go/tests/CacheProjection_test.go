@@ -0,0 +1,102 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/saichler/l8types/go/testtypes"
+	"github.com/saichler/l8utils/go/utils/cache"
+)
+
+func TestCacheProjection(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+	models := make(map[string]*testtypes.TestProto)
+	for i := 1; i <= 10; i++ {
+		m := createModel(i)
+		m.MyBool = true
+		models[m.MyString] = m
+		c.Post(m, false)
+	}
+	q := createIQuery("select MyString,MyInt32 from TestProto", res)
+
+	c.SetProjectionMode(cache.ProjectObjects)
+	for _, copyOnWrite := range []bool{false, true} {
+		c.SetCopyOnWrite(copyOnWrite)
+		elems, _ := c.Fetch(0, 0, q)
+		if len(elems) != 10 {
+			t.Fatalf("Expected 10 projected elements, got %d", len(elems))
+		}
+		for _, elem := range elems {
+			item, ok := elem.(*testtypes.TestProto)
+			if !ok || item.MyString == "" || item.MyInt32 != models[item.MyString].MyInt32 {
+				t.Fatalf("Expected trimmed elements with the selected properties, got %v", elem)
+			}
+			if item.MyBool {
+				t.Errorf("Expected the unselected properties to be empty, got %v", item)
+			}
+		}
+		// the projection trims copies, the cached elements stay whole
+		for _, m := range models {
+			item, err := c.Get(m)
+			if err != nil || !item.(*testtypes.TestProto).MyBool {
+				t.Fatalf("Expected the whole cached element after a projection, got %v", item)
+			}
+		}
+	}
+
+	c.SetProjectionMode(cache.ProjectRows)
+	elems, _ := c.Fetch(0, 5, q)
+	if len(elems) != 5 {
+		t.Fatalf("Expected a page of 5 rows, got %d", len(elems))
+	}
+	for _, elem := range elems {
+		row, ok := elem.(*cache.ProjectedRow)
+		if !ok {
+			t.Fatalf("Expected projected rows, got %T", elem)
+		}
+		expected, ok := models[row.Key]
+		if !ok {
+			t.Fatalf("Expected the row key to be a primary key, got %s", row.Key)
+		}
+		if len(row.Values) != 2 || row.Values["myint32"] != expected.MyInt32 {
+			t.Errorf("Expected the values of the selected properties, got %v", row.Values)
+		}
+	}
+
+	// select * returns all the top level properties
+	elems, _ = c.Fetch(0, 0, createIQuery("select * from TestProto", res))
+	if len(elems) != 10 {
+		t.Fatalf("Expected 10 rows, got %d", len(elems))
+	}
+	for _, elem := range elems {
+		row, ok := elem.(*cache.ProjectedRow)
+		if !ok {
+			t.Fatalf("Expected projected rows, got %T", elem)
+		}
+		expected := models[row.Key]
+		if expected == nil || row.Values["mystring"] != expected.MyString ||
+			row.Values["myint32"] != expected.MyInt32 || row.Values["mybool"] != true {
+			t.Errorf("Expected the values of all the properties, got %v", row.Values)
+		}
+	}
+
+	c.SetProjectionMode(cache.ProjectNone)
+	elems, _ = c.Fetch(0, 1, q)
+	if item, ok := elems[0].(*testtypes.TestProto); !ok || !item.MyBool {
+		t.Errorf("Expected full elements without projection, got %v", elems[0])
+	}
+}
@@ -0,0 +1,229 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/saichler/l8types/go/testtypes"
+	"github.com/saichler/l8utils/go/utils/cache"
+)
+
+// Test that a prepared query follows Post, Patch and Delete without a full re-prepare
+func TestCacheQueryIncrementalUpdates(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+
+	for i := 1; i <= 5; i++ {
+		m := createModel(i)
+		m.MyBool = false
+		c.Post(m, false)
+	}
+
+	all := createIQuery("select * from TestProto", res)
+	flagged := createIQuery("select * from TestProto where MyBool=true", res)
+
+	elems, metadata := c.Fetch(0, 25, all)
+	if len(elems) != 5 || metadata.KeyCount.Counts[cache.Total] != 5 {
+		t.Fatalf("Expected 5 elements, got %d", len(elems))
+	}
+	elems, _ = c.Fetch(0, 25, flagged)
+	if len(elems) != 0 {
+		t.Fatalf("Expected 0 flagged elements, got %d", len(elems))
+	}
+
+	m6 := createModel(6)
+	m6.MyBool = false
+	c.Post(m6, false)
+	elems, metadata = c.Fetch(0, 25, all)
+	if len(elems) != 6 || metadata.KeyCount.Counts[cache.Total] != 6 {
+		t.Fatalf("Expected 6 elements after post, got %d", len(elems))
+	}
+
+	patch := createModel(2)
+	patch.MyBool = true
+	c.Patch(patch, false)
+	elems, metadata = c.Fetch(0, 25, flagged)
+	if len(elems) != 1 || metadata.KeyCount.Counts[cache.Total] != 1 {
+		t.Fatalf("Expected 1 flagged element after patch, got %d", len(elems))
+	}
+
+	c.Delete(createModel(2), false)
+	elems, _ = c.Fetch(0, 25, flagged)
+	if len(elems) != 0 {
+		t.Fatalf("Expected 0 flagged elements after delete, got %d", len(elems))
+	}
+	elems, metadata = c.Fetch(0, 25, all)
+	if len(elems) != 5 || metadata.KeyCount.Counts[cache.Total] != 5 {
+		t.Fatalf("Expected 5 elements after delete, got %d", len(elems))
+	}
+}
+
+// Test that a sorted prepared query stays ordered when patches change the sort key of
+// its elements in place
+func TestCacheQueryIncrementalSortedUpdates(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+
+	for i := 1; i <= 40; i++ {
+		m := createModel(i)
+		m.MyBool = false
+		c.Post(m, false)
+	}
+	q := createIQuery("select * from TestProto sort-by mybool desc, mystring asc", res)
+	if elems, _ := c.Fetch(0, 0, q); len(elems) != 40 {
+		t.Fatalf("Expected 40 elements, got %d", len(elems))
+	}
+
+	for i := 3; i <= 40; i += 3 {
+		patch := createModel(i)
+		patch.MyBool = true
+		c.Patch(patch, false)
+	}
+	c.Delete(createModel(9), false)
+	c.Delete(createModel(10), false)
+
+	elems, _ := c.Fetch(0, 0, q)
+	if len(elems) != 38 {
+		t.Fatalf("Expected 38 elements, got %d", len(elems))
+	}
+	flagged := 0
+	for i, e := range elems {
+		item := e.(*testtypes.TestProto)
+		if item.MyBool {
+			flagged++
+		}
+		if i == 0 {
+			continue
+		}
+		prev := elems[i-1].(*testtypes.TestProto)
+		if (!prev.MyBool && item.MyBool) || (prev.MyBool == item.MyBool && prev.MyString >= item.MyString) {
+			t.Fatalf("Expected %s before %s to follow the sort order", prev.MyString, item.MyString)
+		}
+	}
+	if flagged != 12 {
+		t.Errorf("Expected 12 flagged elements, got %d", flagged)
+	}
+}
+
+// Test that cursor pages cover every element once, even with inserts between pages
+func TestCacheFetchAfterCursor(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+
+	for i := 1; i <= 5; i++ {
+		c.Post(createModel(i), false)
+	}
+	q := createIQuery("select * from TestProto", res)
+
+	seen := make(map[string]int)
+	cursor := ""
+	pages := 0
+	for {
+		elems, md, next, err := c.FetchAfter(cursor, 2, q)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if md == nil {
+			t.Errorf("Expected metadata on page %d", pages)
+		}
+		for _, e := range elems {
+			seen[e.(*testtypes.TestProto).MyString]++
+		}
+		if pages == 0 {
+			// sorts before the cursor, so it must not shift the following pages
+			c.Post(createModel(0), false)
+		}
+		pages++
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	for i := 1; i <= 5; i++ {
+		if seen[createModel(i).MyString] != 1 {
+			t.Errorf("Expected element %d exactly once, got %d", i, seen[createModel(i).MyString])
+		}
+	}
+
+	if _, _, _, err := c.FetchAfter("not a cursor", 2, q); err == nil {
+		t.Error("Expected error for an invalid cursor")
+	}
+}
+
+// Test sorting on several keys with independent directions
+func TestCacheQueryMultiKeySort(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+
+	for i := 1; i <= 6; i++ {
+		m := createModel(i)
+		m.MyBool = i%2 == 0
+		m.MyInt32 = int32(i)
+		c.Post(m, false)
+	}
+	plain := createIQuery("select * from TestProto", res)
+	q := createIQuery("select * from TestProto sort-by mybool desc, myint32 asc", res)
+	if q.SortBy() == "" {
+		t.Fatal("Expected the parsed query to have a sort clause")
+	}
+
+	// the unsorted query is prepared first, the sorted one must not share its order
+	if elems, _ := c.Fetch(0, 0, plain); len(elems) != 6 {
+		t.Fatalf("Expected 6 elements, got %d", len(elems))
+	}
+	elems, _ := c.Fetch(0, 0, q)
+	expected := []int32{2, 4, 6, 1, 3, 5}
+	if len(elems) != len(expected) {
+		t.Fatalf("Expected %d elements, got %d", len(expected), len(elems))
+	}
+	for i, e := range elems {
+		if e.(*testtypes.TestProto).MyInt32 != expected[i] {
+			t.Errorf("Expected MyInt32 %d at position %d, got %d", expected[i], i, e.(*testtypes.TestProto).MyInt32)
+		}
+	}
+
+	// cursor pages follow the same order
+	_, _, next, err := c.FetchAfter("", 4, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, _, _, _ := c.FetchAfter(next, 4, q)
+	if len(rest) != 2 || rest[0].(*testtypes.TestProto).MyInt32 != 3 {
+		t.Errorf("Expected the last two elements after the cursor, got %d", len(rest))
+	}
+}
+
+// Test that a sort clause with an unknown key is rejected rather than partially applied
+func TestCacheQueryMultiKeySortUnknownKey(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+
+	for i := 1; i <= 3; i++ {
+		c.Post(createModel(i), false)
+	}
+	q := createIQuery("select * from TestProto sort-by mybool desc, nosuchfield asc", res)
+	if _, _, _, err := c.FetchAfter("", 10, q); err == nil {
+		t.Error("Expected error for an unknown sort key")
+	}
+	if elems, _ := c.Fetch(0, 0, q); len(elems) != 0 {
+		t.Errorf("Expected no elements for an unknown sort key, got %d", len(elems))
+	}
+}
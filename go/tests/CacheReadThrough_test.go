@@ -0,0 +1,231 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/l8types/go/testtypes"
+	"github.com/saichler/l8utils/go/utils/cache"
+)
+
+// queryStorage is a storage with the cache disabled that executes queries, sorted by key,
+// failing them with err when set.
+type queryStorage struct {
+	*testStorage
+	queries int
+	err     error
+}
+
+func (s *queryStorage) SupportsQuery(q ifs.IQuery) bool {
+	return true
+}
+
+func (s *queryStorage) Query(q ifs.IQuery, start, limit int) ([]interface{}, int, error) {
+	s.queries++
+	if s.err != nil {
+		return nil, 0, s.err
+	}
+	keys := make([]string, 0)
+	for k, v := range s.data {
+		if q.Match(v) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	total := len(keys)
+	result := make([]interface{}, 0)
+	for i := start; i < len(keys); i++ {
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+		result = append(result, s.data[keys[i]])
+	}
+	return result, total, nil
+}
+
+func postReadThroughModels(c *cache.Cache) {
+	for i := 1; i <= 6; i++ {
+		m := createModel(i)
+		m.MyInt32 = int32(i)
+		m.MyBool = i%2 == 0
+		c.Post(m, false)
+	}
+}
+
+func TestCacheDisabledFetchScan(t *testing.T) {
+	res := newResources()
+	store := newTestStorage(false)
+	c := cache.NewCache(&testtypes.TestProto{}, nil, store, res)
+	defer c.Close()
+	postReadThroughModels(c)
+
+	elems, metadata := c.Fetch(0, 2, createIQuery("select * from TestProto where MyInt32>2", res))
+	if len(elems) != 2 {
+		t.Fatalf("Expected a page of 2 elements from the store, got %d", len(elems))
+	}
+	if metadata.KeyCount.Counts[cache.Total] != 4 {
+		t.Errorf("Expected a total of 4, got %v", metadata.KeyCount.Counts[cache.Total])
+	}
+
+	q := createIQuery("select count(*),sum(MyInt32) from TestProto group by MyBool", res)
+	rows, err := c.FetchAggregate(q, "")
+	if err != nil {
+		t.Fatalf("Failed to fetch the aggregate rows: %s", err.Error())
+	}
+	if len(rows) != 2 {
+		t.Errorf("Expected 2 groups from the store, got %d", len(rows))
+	}
+}
+
+func TestCacheDisabledFetchPushdown(t *testing.T) {
+	res := newResources()
+	store := &queryStorage{testStorage: newTestStorage(false)}
+	c := cache.NewCache(&testtypes.TestProto{}, nil, store, res)
+	defer c.Close()
+	postReadThroughModels(c)
+
+	elems, metadata := c.Fetch(1, 2, createIQuery("select * from TestProto where MyBool=true", res))
+	if store.queries != 1 {
+		t.Fatalf("Expected the query to be executed by the store, got %d queries", store.queries)
+	}
+	if len(elems) != 2 {
+		t.Fatalf("Expected a page of 2 elements, got %d", len(elems))
+	}
+	for _, elem := range elems {
+		if !elem.(*testtypes.TestProto).MyBool {
+			t.Error("Expected only elements matching the criteria")
+		}
+	}
+	if metadata.KeyCount.Counts[cache.Total] != 3 {
+		t.Errorf("Expected a total of 3, got %v", metadata.KeyCount.Counts[cache.Total])
+	}
+
+	q := createIQuery("select count(*) from TestProto where MyBool=true", res)
+	c.SetAggregateResultMode(cache.AggregateRows)
+	rows, _ := c.Fetch(0, 0, q)
+	if store.queries != 2 {
+		t.Errorf("Expected the aggregate WHERE to be executed by the store, got %d queries", store.queries)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("Expected a single aggregate row, got %d", len(rows))
+	}
+	row := rows[0].(*cache.AggregateRow)
+	if count, _ := cache.ToFloat64(row.Aggregates[q.Aggregates()[0].Alias]); count != 3 {
+		t.Errorf("Expected count 3, got %v", row.Aggregates[q.Aggregates()[0].Alias])
+	}
+}
+
+func TestCacheDisabledFetchAfterPushdown(t *testing.T) {
+	res := newResources()
+	store := &queryStorage{testStorage: newTestStorage(false)}
+	c := cache.NewCache(&testtypes.TestProto{}, nil, store, res)
+	defer c.Close()
+	postReadThroughModels(c)
+
+	q := createIQuery("select * from TestProto where MyInt32>1", res)
+	seen := make(map[int32]bool)
+	cursor := ""
+	for page := 0; page < 3; page++ {
+		elems, metadata, next, err := c.FetchAfter(cursor, 2, q)
+		if err != nil {
+			t.Fatalf("Failed to fetch page %d: %s", page, err.Error())
+		}
+		if store.queries != page+1 {
+			t.Fatalf("Expected every page to be executed by the store, got %d queries", store.queries)
+		}
+		if metadata == nil || metadata.KeyCount.Counts[cache.Total] != 5 {
+			t.Errorf("Expected a total of 5 on page %d, got %v", page, metadata)
+		}
+		for _, elem := range elems {
+			seen[elem.(*testtypes.TestProto).MyInt32] = true
+		}
+		if page < 2 && (len(elems) != 2 || next == "") {
+			t.Fatalf("Expected a full page %d with a next cursor, got %d", page, len(elems))
+		}
+		if page == 2 && (len(elems) != 1 || next != "") {
+			t.Fatalf("Expected a last page of 1 element without a next cursor, got %d", len(elems))
+		}
+		cursor = next
+	}
+	if len(seen) != 5 || seen[1] {
+		t.Errorf("Expected the 5 matching elements once each, got %v", seen)
+	}
+}
+
+func TestCacheDisabledFetchStoreError(t *testing.T) {
+	res := newResources()
+	store := &queryStorage{testStorage: newTestStorage(false)}
+	c := cache.NewCache(&testtypes.TestProto{}, nil, store, res)
+	defer c.Close()
+	postReadThroughModels(c)
+	store.err = errors.New("Storage is down")
+
+	q := createIQuery("select * from TestProto where MyInt32>1", res)
+	if _, _, _, err := c.FetchAfter("", 2, q); err == nil {
+		t.Error("Expected FetchAfter to return the store error")
+	}
+	if elems, _ := c.Fetch(0, 2, q); len(elems) != 0 {
+		t.Errorf("Expected Fetch to fetch nothing when the store fails, got %d", len(elems))
+	}
+	aggregate := createIQuery("select count(*) from TestProto where MyBool=true", res)
+	if _, err := c.FetchAggregate(aggregate, ""); err == nil {
+		t.Error("Expected FetchAggregate to return the store error")
+	}
+}
+
+// scanStorage is a storage with the cache disabled that counts its scans.
+type scanStorage struct {
+	*testStorage
+	scans int
+}
+
+func (s *scanStorage) Collect(f func(interface{}) (bool, interface{})) map[string]interface{} {
+	s.scans++
+	return s.testStorage.Collect(f)
+}
+
+func TestCacheDisabledFetchAfterReusesScan(t *testing.T) {
+	res := newResources()
+	store := &scanStorage{testStorage: newTestStorage(false)}
+	c := cache.NewCache(&testtypes.TestProto{}, nil, store, res)
+	defer c.Close()
+	postReadThroughModels(c)
+	store.scans = 0
+
+	q := createIQuery("select * from TestProto where MyInt32>0", res)
+	_, _, next, err := c.FetchAfter("", 2, q)
+	if err != nil {
+		t.Fatalf("Failed to fetch the first page: %s", err.Error())
+	}
+	_, _, next, _ = c.FetchAfter(next, 2, q)
+	if store.scans != 1 {
+		t.Fatalf("Expected the pages of a fetch to reuse its scan, got %d scans", store.scans)
+	}
+
+	// a write through the cache drops the scan
+	m := createModel(7)
+	m.MyInt32 = 7
+	c.Post(m, false)
+	elems, _, _, _ := c.FetchAfter(next, 2, q)
+	if store.scans != 2 {
+		t.Errorf("Expected a write to drop the scan, got %d scans", store.scans)
+	}
+	if len(elems) != 2 {
+		t.Errorf("Expected a page of 2 elements after the write, got %d", len(elems))
+	}
+}
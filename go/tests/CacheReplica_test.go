@@ -0,0 +1,172 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/saichler/l8types/go/testtypes"
+	"github.com/saichler/l8types/go/types/l8notify"
+	"github.com/saichler/l8utils/go/utils/cache"
+)
+
+func TestCacheReplicaApply(t *testing.T) {
+	res := newResources()
+	leader := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer leader.Close()
+	leader.SetNotificationsFor("TestService", 1)
+	follower := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer follower.Close()
+	replica := cache.NewReplica(follower, nil)
+
+	sets := make([]*l8notify.L8NotificationSet, 0)
+	for i := 1; i <= 5; i++ {
+		n, _, err := leader.Post(createModel(i), true)
+		if err != nil {
+			t.Fatalf("Failed to post: %s", err.Error())
+		}
+		sets = append(sets, n)
+	}
+	patch := createModel(2)
+	patch.MyBool = !createModel(2).MyBool
+	n, _, err := leader.Patch(patch, true)
+	if err != nil || n == nil {
+		t.Fatalf("Expected a patch notification")
+	}
+	sets = append(sets, n)
+	n, _, _ = leader.Delete(createModel(1), true)
+	sets = append(sets, n)
+
+	for _, n := range sets {
+		if err := replica.Apply(n); err != nil {
+			t.Fatalf("Failed to apply notification: %s", err.Error())
+		}
+	}
+	// applying again is skipped
+	for _, n := range sets {
+		replica.Apply(n)
+	}
+
+	if follower.Size() != 4 {
+		t.Fatalf("Expected 4 replicated elements, got %d", follower.Size())
+	}
+	item, err := follower.Get(createModel(2))
+	if err != nil || item.(*testtypes.TestProto).MyBool != patch.MyBool {
+		t.Errorf("Expected the patch to be replicated")
+	}
+	if replica.Sequence(sets[0].Source) != uint32(len(sets)) {
+		t.Errorf("Expected next sequence %d, got %d", len(sets), replica.Sequence(sets[0].Source))
+	}
+}
+
+func TestCacheReplicaResync(t *testing.T) {
+	res := newResources()
+	leader := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer leader.Close()
+	leader.SetNotificationsFor("TestService", 1)
+	follower := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer follower.Close()
+
+	requested := make([]uint32, 0)
+	replica := cache.NewReplica(follower, func(source string, sequence uint32) {
+		requested = append(requested, sequence)
+	})
+
+	first, _, _ := leader.Post(createModel(1), true)
+	replica.Apply(first)
+	// the notification of the second element is lost
+	leader.Post(createModel(2), true)
+	third, _, _ := leader.Post(createModel(3), true)
+	snapshot := &bytes.Buffer{}
+	leader.Snapshot(snapshot)
+	fourth, _, _ := leader.Post(createModel(4), true)
+
+	replica.Apply(third)
+	if !replica.Resyncing(third.Source) {
+		t.Fatal("Expected the replica to resync after a gap")
+	}
+	if len(requested) != 1 || requested[0] != 1 {
+		t.Fatalf("Expected a resync request from sequence 1, got %v", requested)
+	}
+	replica.Apply(fourth)
+	if follower.Size() != 1 {
+		t.Fatalf("Expected no elements applied while resyncing, got %d", follower.Size())
+	}
+
+	if err := replica.ApplySnapshot(third.Source, snapshot); err != nil {
+		t.Fatalf("Failed to apply snapshot: %s", err.Error())
+	}
+	if replica.Resyncing(third.Source) {
+		t.Error("Expected the replica to be in sync after the snapshot")
+	}
+	if follower.Size() != 4 {
+		t.Errorf("Expected 4 elements after the snapshot, got %d", follower.Size())
+	}
+}
+
+func TestCacheReplicaSnapshotKeepsOtherSources(t *testing.T) {
+	res := newResources()
+	leaderA := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer leaderA.Close()
+	leaderA.SetNotificationsFor("TestService", 1)
+	leaderB := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer leaderB.Close()
+	leaderB.SetNotificationsFor("TestService", 1)
+	follower := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer follower.Close()
+	replica := cache.NewReplica(follower, nil)
+
+	// the leaders share the resources, so their sets are told apart by renaming the Source
+	applyFrom := func(source string, n *l8notify.L8NotificationSet) {
+		n.Source = source
+		if err := replica.Apply(n); err != nil {
+			t.Fatalf("Failed to apply notification: %s", err.Error())
+		}
+	}
+	for i := 1; i <= 2; i++ {
+		n, _, _ := leaderA.Post(createModel(i), true)
+		applyFrom("leader-a", n)
+	}
+	for i := 11; i <= 12; i++ {
+		n, _, _ := leaderB.Post(createModel(i), true)
+		applyFrom("leader-b", n)
+	}
+
+	// the delete of element 2 is lost
+	leaderA.Delete(createModel(2), true)
+	n, _, _ := leaderA.Post(createModel(3), true)
+	applyFrom("leader-a", n)
+	if !replica.Resyncing("leader-a") {
+		t.Fatal("Expected leader-a to resync after a gap")
+	}
+
+	snapshot := &bytes.Buffer{}
+	leaderA.Snapshot(snapshot)
+	if err := replica.ApplySnapshot("leader-a", snapshot); err != nil {
+		t.Fatalf("Failed to apply snapshot: %s", err.Error())
+	}
+
+	if follower.Size() != 4 {
+		t.Errorf("Expected 4 elements after the snapshot, got %d", follower.Size())
+	}
+	if _, err := follower.Get(createModel(2)); err == nil {
+		t.Error("Expected the element deleted on leader-a to be removed by its snapshot")
+	}
+	for _, i := range []int{1, 3, 11, 12} {
+		if _, err := follower.Get(createModel(i)); err != nil {
+			t.Errorf("Expected element %d to be kept, got %s", i, err.Error())
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/saichler/l8types/go/testtypes"
+	"github.com/saichler/l8utils/go/utils/cache"
+)
+
+func TestCacheRevisionConflict(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+
+	if _, _, err := c.PutIf(createModel(1), 0, false); err != nil {
+		t.Fatalf("Expected PutIf with revision 0 to add the element, got %s", err.Error())
+	}
+	_, revision, err := c.GetWithRevision(createModel(1))
+	if err != nil || revision != 1 {
+		t.Fatalf("Expected revision 1, got %d", revision)
+	}
+
+	// another writer changes the element
+	c.Put(createModel(1), false)
+
+	_, _, err = c.PutIf(createModel(1), revision, false)
+	var conflict *cache.ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Expected a conflict error, got %v", err)
+	}
+	if conflict.Expected != 1 || conflict.Actual != 2 {
+		t.Errorf("Expected conflict of revision 1 against 2, got %d against %d", conflict.Expected, conflict.Actual)
+	}
+
+	if _, _, err = c.DeleteIf(createModel(1), 2, false); err != nil {
+		t.Fatalf("Expected DeleteIf with the current revision to succeed, got %s", err.Error())
+	}
+	if c.Size() != 0 {
+		t.Errorf("Expected empty cache, got %d", c.Size())
+	}
+}
+
+func TestCacheRevisionNotReusedAfterDelete(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+
+	c.Post(createModel(1), false)
+	_, stale, err := c.GetWithRevision(createModel(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the element is deleted and added again, its old revision must not match
+	c.Delete(createModel(1), false)
+	c.Post(createModel(1), false)
+	_, revision, err := c.GetWithRevision(createModel(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision <= stale {
+		t.Errorf("Expected the re-added element revision to be above %d, got %d", stale, revision)
+	}
+
+	_, _, err = c.PutIf(createModel(1), stale, false)
+	var conflict *cache.ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Expected a conflict error for the stale revision, got %v", err)
+	}
+	if conflict.Expected != stale || conflict.Actual != revision {
+		t.Errorf("Expected conflict of revision %d against %d, got %d against %d", stale, revision, conflict.Expected, conflict.Actual)
+	}
+}
@@ -0,0 +1,110 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/saichler/l8types/go/testtypes"
+	"github.com/saichler/l8utils/go/utils/cache"
+)
+
+func TestCacheSnapshotRestore(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+
+	for i := 1; i <= 10; i++ {
+		c.Post(createModel(i), false)
+	}
+
+	buff := &bytes.Buffer{}
+	if err := c.Snapshot(buff); err != nil {
+		t.Fatalf("Failed to snapshot cache: %s", err.Error())
+	}
+
+	restored, err := cache.RestoreCache(bytes.NewReader(buff.Bytes()), &testtypes.TestProto{}, nil, res)
+	if err != nil {
+		t.Fatalf("Failed to restore cache: %s", err.Error())
+	}
+	defer restored.Close()
+
+	if restored.Size() != 10 {
+		t.Fatalf("Expected 10 restored elements, got %d", restored.Size())
+	}
+	for i := 1; i <= 10; i++ {
+		item, err := restored.Get(createModel(i))
+		if err != nil || item == nil {
+			t.Fatalf("Expected element %d to be restored", i)
+		}
+		if item.(*testtypes.TestProto).MyInt32 != createModel(i).MyInt32 {
+			t.Errorf("Expected restored element %d to equal the original", i)
+		}
+	}
+
+	elems, _ := restored.Fetch(0, 100, createIQuery("select * from TestProto", res))
+	if len(elems) != 10 {
+		t.Errorf("Expected restored cache queries to return 10 elements, got %d", len(elems))
+	}
+}
+
+func TestCacheSnapshotRestoreRevisions(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+
+	for i := 1; i <= 3; i++ {
+		c.Post(createModel(i), false)
+	}
+	m := createModel(2)
+	m.MyInt32 = 200
+	c.Put(m, false)
+	_, revision, err := c.GetWithRevision(createModel(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buff := &bytes.Buffer{}
+	if err = c.Snapshot(buff); err != nil {
+		t.Fatalf("Failed to snapshot cache: %s", err.Error())
+	}
+	restored, err := cache.RestoreCache(bytes.NewReader(buff.Bytes()), &testtypes.TestProto{}, nil, res)
+	if err != nil {
+		t.Fatalf("Failed to restore cache: %s", err.Error())
+	}
+	defer restored.Close()
+
+	_, restoredRevision, err := restored.GetWithRevision(createModel(2))
+	if err != nil || restoredRevision != revision {
+		t.Fatalf("Expected revision %d to be restored, got %d", revision, restoredRevision)
+	}
+	// the revision counter is restored too, so a new revision is not reused
+	restored.Post(createModel(4), false)
+	_, newRevision, _ := restored.GetWithRevision(createModel(4))
+	if newRevision <= revision {
+		t.Errorf("Expected a new revision above %d, got %d", revision, newRevision)
+	}
+	if _, _, err = restored.PutIf(m, revision, false); err != nil {
+		t.Errorf("Expected a conditional put on the restored revision to succeed, got %s", err.Error())
+	}
+}
+
+func TestCacheRestoreInvalidSnapshot(t *testing.T) {
+	res := newResources()
+	_, err := cache.RestoreCache(bytes.NewReader([]byte("not a snapshot")), &testtypes.TestProto{}, nil, res)
+	if err == nil {
+		t.Fatal("Expected error when restoring an invalid snapshot")
+	}
+}
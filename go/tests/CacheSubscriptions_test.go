@@ -226,3 +226,61 @@ func TestSubscriptionRefreshPreventsEviction(t *testing.T) {
 		t.Error("Expected subscriber to survive after refresh")
 	}
 }
+
+func TestSubscriptionMultiplePerAAAId(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+
+	c.RegisterSubscriptionFor("aaa-1", "tab-2", 2, "q2", 0)
+	c.RegisterSubscriptionFor("aaa-1", "tab-1", 1, "q1", 0)
+	c.RegisterSubscriptionFor("aaa-2", "tab-1", 3, "q3", 0)
+
+	subs := c.SubscriptionsOf("aaa-1")
+	if len(subs) != 2 {
+		t.Fatalf("Expected 2 subscriptions of aaa-1, got %d", len(subs))
+	}
+	if subs[0].SubscriptionId != "tab-1" || subs[1].SubscriptionId != "tab-2" {
+		t.Errorf("Expected subscriptions sorted by id, got '%s', '%s'", subs[0].SubscriptionId, subs[1].SubscriptionId)
+	}
+
+	c.UnregisterSubscriptionFor("aaa-1", "tab-2")
+	if len(c.SubscriptionsOf("aaa-1")) != 1 {
+		t.Errorf("Expected 1 subscription of aaa-1 after unregistering tab-2")
+	}
+
+	c.RegisterSubscriptionFor("aaa-1", "tab-3", 4, "q4", 0)
+	c.UnregisterSubscription("aaa-1")
+	if len(c.SubscriptionsOf("aaa-1")) != 0 {
+		t.Errorf("Expected no subscriptions of aaa-1 after unregistering all")
+	}
+	if len(c.Subscribers()) != 1 {
+		t.Errorf("Expected only the subscription of aaa-2, got %d", len(c.Subscribers()))
+	}
+}
+
+func TestSubscriptionRefreshPerSubscription(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+
+	c.RegisterSubscriptionFor("aaa-1", "tab-1", 1, "q1", 0)
+	c.RegisterSubscriptionFor("aaa-1", "tab-2", 2, "q2", 0)
+	c.RegisterSubscriptionFor("aaa-1", "tab-3", 3, "q3", 60)
+	time.Sleep(2 * time.Second)
+	if !c.RefreshSubscription("aaa-1", "tab-2") {
+		t.Fatalf("Expected refresh of an existing subscription to succeed")
+	}
+
+	evicted := c.EvictStaleSubscriptions(1)
+	if evicted != 1 {
+		t.Errorf("Expected 1 evicted, got %d", evicted)
+	}
+	subs := c.SubscriptionsOf("aaa-1")
+	if len(subs) != 2 || subs[0].SubscriptionId != "tab-2" || subs[1].SubscriptionId != "tab-3" {
+		t.Errorf("Expected the refreshed tab-2 and long lived tab-3 to remain")
+	}
+	if c.RefreshSubscription("aaa-1", "tab-1") {
+		t.Errorf("Expected refresh of an evicted subscription to fail")
+	}
+}
@@ -0,0 +1,108 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/saichler/l8types/go/testtypes"
+	"github.com/saichler/l8utils/go/utils/cache"
+)
+
+func TestCacheTimeBucket(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+	// MyInt32 is used as the epoch seconds, buckets of 10 seconds are 0:2, 10:0 and 20:1
+	for _, i := range []int{1, 2, 25} {
+		m := createModel(i)
+		m.MyInt32 = int32(i)
+		c.Post(m, false)
+	}
+
+	q := createIQuery("select count(*) from TestProto group by MyInt32", res)
+	countAlias := q.Aggregates()[0].Alias
+	groupBy := q.GroupBy()[0]
+
+	err := c.SetTimeBucket("MyInt32", &cache.TimeBucket{Width: 10 * time.Second, FillEmpty: true})
+	if err != nil {
+		t.Fatalf("Failed to set time bucket: %s", err.Error())
+	}
+	if c.SetTimeBucket("MyString", &cache.TimeBucket{Width: time.Second}) == nil {
+		t.Errorf("Expected an error for a time bucket of a string property")
+	}
+
+	check := func(name string) {
+		rows, err := c.FetchAggregate(q, "")
+		if err != nil {
+			t.Fatalf("%s: failed to fetch aggregate: %s", name, err.Error())
+		}
+		if len(rows) != 3 {
+			t.Fatalf("%s: expected 3 buckets, got %d", name, len(rows))
+		}
+		counts := []float64{2, 0, 1}
+		for i, row := range rows {
+			bucket, ok := row.GroupBy[groupBy].(time.Time)
+			if !ok || !bucket.Equal(time.Unix(int64(i*10), 0)) {
+				t.Errorf("%s: expected bucket %d to start at %ds, got %v", name, i, i*10, row.GroupBy[groupBy])
+			}
+			if count, _ := cache.ToFloat64(row.Aggregates[countAlias]); count != counts[i] {
+				t.Errorf("%s: expected count %v in bucket %d, got %v", name, counts[i], i, row.Aggregates[countAlias])
+			}
+		}
+	}
+	check("scan")
+	if err := c.RegisterAggregate(q); err != nil {
+		t.Fatalf("Failed to register aggregate: %s", err.Error())
+	}
+	check("registered")
+
+	c.SetTimeBucket("MyInt32", nil)
+	rows, _ := c.FetchAggregate(q, "")
+	if len(rows) != 3 {
+		t.Errorf("Expected a group per timestamp without a time bucket, got %d", len(rows))
+	}
+	for _, row := range rows {
+		if _, ok := row.GroupBy[groupBy].(time.Time); ok {
+			t.Errorf("Expected raw timestamps without a time bucket")
+		}
+	}
+}
+
+func TestCacheTimeBucketFillLimit(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+	for i, stamp := range []int32{1, 1000000} {
+		m := createModel(i + 1)
+		m.MyInt32 = stamp
+		c.Post(m, false)
+	}
+
+	q := createIQuery("select count(*) from TestProto group by MyInt32", res)
+	err := c.SetTimeBucket("MyInt32", &cache.TimeBucket{Width: time.Second, FillEmpty: true, MaxBuckets: 100})
+	if err != nil {
+		t.Fatalf("Failed to set time bucket: %s", err.Error())
+	}
+	if _, err = c.FetchAggregate(q, ""); err == nil {
+		t.Error("Expected an error for filling more buckets than the limit")
+	}
+
+	c.SetTimeBucket("MyInt32", &cache.TimeBucket{Width: time.Second})
+	rows, err := c.FetchAggregate(q, "")
+	if err != nil || len(rows) != 2 {
+		t.Errorf("Expected the 2 buckets without filling, got %d", len(rows))
+	}
+}
@@ -0,0 +1,111 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/saichler/l8types/go/testtypes"
+	"github.com/saichler/l8utils/go/utils/cache"
+)
+
+func checkWALCache(t *testing.T, c *cache.Cache) {
+	if c.Size() != 9 {
+		t.Fatalf("Expected 9 elements after replay, got %d", c.Size())
+	}
+	if _, err := c.Get(createModel(3)); err == nil {
+		t.Error("Expected deleted element to stay deleted after replay")
+	}
+	item, err := c.Get(createModel(4))
+	if err != nil || item.(*testtypes.TestProto).MyBool != true {
+		t.Error("Expected patched element to be replayed")
+	}
+}
+
+func TestCacheWALReplay(t *testing.T) {
+	res := newResources()
+	config := &cache.WALConfig{Dir: t.TempDir(), SyncPolicy: cache.WALSyncAlways, SegmentSize: 1024}
+
+	c, err := cache.NewCacheWithWAL(&testtypes.TestProto{}, nil, nil, res, config)
+	if err != nil {
+		t.Fatalf("Failed to create cache with WAL: %s", err.Error())
+	}
+	for i := 1; i <= 10; i++ {
+		c.Post(createModel(i), false)
+	}
+	c.Delete(createModel(3), false)
+	patch := createModel(4)
+	patch.MyBool = true
+	c.Patch(patch, false)
+	c.Close()
+
+	replayed, err := cache.NewCacheWithWAL(&testtypes.TestProto{}, nil, nil, res, config)
+	if err != nil {
+		t.Fatalf("Failed to replay WAL: %s", err.Error())
+	}
+	checkWALCache(t, replayed)
+
+	if err = replayed.CompactWAL(); err != nil {
+		t.Fatalf("Failed to compact WAL: %s", err.Error())
+	}
+	replayed.Close()
+
+	compacted, err := cache.NewCacheWithWAL(&testtypes.TestProto{}, nil, nil, res, config)
+	if err != nil {
+		t.Fatalf("Failed to replay compacted WAL: %s", err.Error())
+	}
+	defer compacted.Close()
+	checkWALCache(t, compacted)
+}
+
+func TestCacheWALOptionAndAutoCompaction(t *testing.T) {
+	res := newResources()
+	dir := t.TempDir()
+	config := &cache.WALConfig{Dir: dir, SyncPolicy: cache.WALSyncNone, SegmentSize: 1024, CompactSegments: 4}
+
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res, cache.WithWAL(config))
+	for i := 1; i <= 10; i++ {
+		c.Post(createModel(i), false)
+	}
+	c.Delete(createModel(3), false)
+	for i := 0; i < 200; i++ {
+		m := createModel(4)
+		m.MyBool = i%2 == 1
+		c.Put(m, false)
+	}
+
+	// the log grew well past 4 segments, so it is compacted in the background
+	segments := 0
+	for wait := 0; wait < 50; wait++ {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		segments = len(entries)
+		if segments <= 4 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if segments > 4 {
+		t.Errorf("Expected the WAL to be compacted to at most 4 segments, got %d", segments)
+	}
+	c.Close()
+
+	replayed := cache.NewCache(&testtypes.TestProto{}, nil, nil, res, cache.WithWAL(config))
+	defer replayed.Close()
+	checkWALCache(t, replayed)
+}
@@ -0,0 +1,136 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/saichler/l8types/go/testtypes"
+	"github.com/saichler/l8utils/go/utils/cache"
+)
+
+func TestCacheWatchEnterLeave(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+	c.SetNotificationsFor("TestService", 1)
+
+	mtx := &sync.Mutex{}
+	kinds := make([]cache.WatchEventKind, 0)
+	c.OnWatchEvents(func(events []*cache.WatchEvent) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		for _, event := range events {
+			kinds = append(kinds, event.Kind)
+		}
+	})
+	c.Watch("aaa-1", "tab-1", createIQuery("select * from TestProto where MyBool=true", res))
+
+	item := createModel(1)
+	item.MyBool = false
+	_, cn, _ := c.Post(item, true)
+	if cn != nil {
+		t.Errorf("Expected no client notification for a change outside the watch")
+	}
+
+	item = createModel(1)
+	item.MyBool = true
+	_, cn, _ = c.Put(item, true)
+	if cn == nil || !cn.AaaIds["aaa-1"] {
+		t.Fatalf("Expected a client notification for aaa-1")
+	}
+
+	item = createModel(1)
+	item.MyBool = false
+	c.Put(item, true)
+
+	time.Sleep(50 * time.Millisecond)
+	mtx.Lock()
+	defer mtx.Unlock()
+	if len(kinds) != 2 || kinds[0] != cache.WatchEnter || kinds[1] != cache.WatchLeave {
+		t.Errorf("Expected enter and leave events, got %v", kinds)
+	}
+}
+
+func TestCacheWatchPutWithoutChanges(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+	c.SetNotificationsFor("TestService", 1)
+
+	mtx := &sync.Mutex{}
+	kinds := make([]cache.WatchEventKind, 0)
+	c.OnWatchEvents(func(events []*cache.WatchEvent) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		for _, event := range events {
+			kinds = append(kinds, event.Kind)
+		}
+	})
+	c.Watch("aaa-1", "tab-1", createIQuery("select * from TestProto where MyBool=true", res))
+
+	item := createModel(1)
+	item.MyBool = true
+	c.Post(item, true)
+	n, cn, err := c.Put(item, true)
+	if err != nil || n != nil || cn != nil {
+		t.Errorf("Expected no notifications for a Put without changes")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	mtx.Lock()
+	defer mtx.Unlock()
+	if len(kinds) != 1 || kinds[0] != cache.WatchEnter {
+		t.Errorf("Expected only the enter event, got %v", kinds)
+	}
+}
+
+func TestCacheWatchSlowListenerClosed(t *testing.T) {
+	res := newResources()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, nil, res)
+	defer c.Close()
+
+	release := make(chan struct{})
+	mtx := &sync.Mutex{}
+	received := make([]*cache.WatchEvent, 0)
+	c.SetWatchQueueLimit(2)
+	c.OnWatchEvents(func(events []*cache.WatchEvent) {
+		<-release
+		mtx.Lock()
+		defer mtx.Unlock()
+		received = append(received, events...)
+	})
+	c.Watch("aaa-1", "tab-1", createIQuery("select * from TestProto", res))
+
+	// the listener is stuck, the watch must be closed instead of queueing every change
+	for i := 1; i <= 20; i++ {
+		c.Post(createModel(i), false)
+	}
+	if subs := c.SubscriptionsOf("aaa-1"); len(subs) != 0 {
+		t.Errorf("Expected the slow watch to be unregistered, got %d subscriptions", len(subs))
+	}
+	close(release)
+
+	time.Sleep(50 * time.Millisecond)
+	mtx.Lock()
+	defer mtx.Unlock()
+	if len(received) == 0 || len(received) > 4 {
+		t.Fatalf("Expected at most 4 events of the closed watch, got %d", len(received))
+	}
+	if received[len(received)-1].Kind != cache.WatchClosed {
+		t.Errorf("Expected the last event to close the watch, got %v", received[len(received)-1].Kind)
+	}
+}
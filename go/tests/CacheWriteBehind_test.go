@@ -0,0 +1,391 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/saichler/l8types/go/testtypes"
+	"github.com/saichler/l8utils/go/utils/cache"
+)
+
+// failingStorage is a thread safe storage whose writes fail while it is down, and
+// whose writes of the failing key always fail.
+type failingStorage struct {
+	mtx     sync.Mutex
+	data    map[string]interface{}
+	down    bool
+	failing string
+}
+
+func newFailingStorage() *failingStorage {
+	return &failingStorage{data: make(map[string]interface{})}
+}
+
+func (s *failingStorage) setDown(down bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.down = down
+}
+
+func (s *failingStorage) setFailing(key string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.failing = key
+}
+
+func (s *failingStorage) size() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.data)
+}
+
+func (s *failingStorage) Get(key string) (interface{}, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	val, ok := s.data[key]
+	if !ok {
+		return nil, errors.New("Not found")
+	}
+	return val, nil
+}
+
+func (s *failingStorage) Put(key string, value interface{}) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.down || key == s.failing {
+		return errors.New("Storage is down")
+	}
+	s.data[key] = value
+	return nil
+}
+
+func (s *failingStorage) Delete(key string) (interface{}, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.down || key == s.failing {
+		return nil, errors.New("Storage is down")
+	}
+	val := s.data[key]
+	delete(s.data, key)
+	return val, nil
+}
+
+func (s *failingStorage) Collect(f func(interface{}) (bool, interface{})) map[string]interface{} {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	result := make(map[string]interface{})
+	for k, v := range s.data {
+		if include, val := f(v); include {
+			result[k] = val
+		}
+	}
+	return result
+}
+
+func (s *failingStorage) CacheEnabled() bool {
+	return true
+}
+
+func waitForWrites(c *cache.Cache) {
+	deadline := time.Now().Add(5 * time.Second)
+	for c.PendingWrites() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCacheWriteBehindRetry(t *testing.T) {
+	res := newResources()
+	store := newFailingStorage()
+	config := &cache.WriteBehindConfig{Dir: t.TempDir(), FlushInterval: 10 * time.Millisecond,
+		RetryBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+
+	c := cache.NewCache(&testtypes.TestProto{}, nil, store, res)
+	if err := c.SetWriteBehind(config); err != nil {
+		t.Fatalf("Failed to set write-behind: %s", err.Error())
+	}
+	store.setDown(true)
+	for i := 1; i <= 10; i++ {
+		if _, _, err := c.Post(createModel(i), false); err != nil {
+			t.Fatalf("Expected post to succeed while the store is down: %s", err.Error())
+		}
+	}
+	c.Delete(createModel(3), false)
+	if c.PendingWrites() != 10 {
+		t.Fatalf("Expected 10 pending writes, got %d", c.PendingWrites())
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if store.size() != 0 {
+		t.Fatalf("Expected no writes while the store is down, got %d", store.size())
+	}
+
+	store.setDown(false)
+	waitForWrites(c)
+	if c.PendingWrites() != 0 || store.size() != 9 {
+		t.Fatalf("Expected 9 elements in the store after retry, got %d", store.size())
+	}
+	report, err := c.Reconcile()
+	if err != nil || !report.Consistent() {
+		t.Fatalf("Expected cache and store to be consistent, got %v %v", report, err)
+	}
+	c.Close()
+}
+
+func TestCacheWriteBehindReplay(t *testing.T) {
+	res := newResources()
+	store := newFailingStorage()
+	config := &cache.WriteBehindConfig{Dir: t.TempDir(), FlushInterval: time.Hour}
+
+	c := cache.NewCache(&testtypes.TestProto{}, nil, store, res)
+	if err := c.SetWriteBehind(config); err != nil {
+		t.Fatalf("Failed to set write-behind: %s", err.Error())
+	}
+	store.setDown(true)
+	for i := 1; i <= 5; i++ {
+		c.Post(createModel(i), false)
+	}
+	patch := createModel(2)
+	patch.MyBool = true
+	c.Patch(patch, false)
+	c.Delete(createModel(5), false)
+	// the queued writes cannot be flushed, as after a crash
+	c.Close()
+
+	store.setDown(false)
+	restarted := cache.NewCache(&testtypes.TestProto{}, nil, store, res)
+	if err := restarted.SetWriteBehind(config); err != nil {
+		t.Fatalf("Failed to replay the write-behind queue: %s", err.Error())
+	}
+	if restarted.PendingWrites() != 5 {
+		t.Fatalf("Expected 5 replayed writes, got %d", restarted.PendingWrites())
+	}
+	// the replayed writes are in the cache before they are flushed
+	if restarted.Size() != 4 {
+		t.Fatalf("Expected the 4 replayed elements in the cache, got %d", restarted.Size())
+	}
+	if item, err := restarted.Get(patch); err != nil || !item.(*testtypes.TestProto).MyBool {
+		t.Fatalf("Expected the replayed patch in the cache, got %v %v", item, err)
+	}
+	if item, _ := restarted.Get(createModel(5)); item != nil {
+		t.Fatalf("Expected the replayed delete in the cache, got %v", item)
+	}
+	if err := restarted.FlushWrites(); err != nil {
+		t.Fatalf("Failed to flush writes: %s", err.Error())
+	}
+	pk, _, _ := restarted.KeysFor(patch)
+	item, err := store.Get(pk)
+	if err != nil || item.(*testtypes.TestProto).MyBool != true {
+		t.Error("Expected the patched element to be written to the store")
+	}
+	restarted.Close()
+}
+
+func TestCacheWriteBehindDeadLetter(t *testing.T) {
+	res := newResources()
+	store := newFailingStorage()
+	type deadLetter struct {
+		pk    string
+		value interface{}
+	}
+	dead := make(chan deadLetter, 1)
+	config := &cache.WriteBehindConfig{Dir: t.TempDir(), FlushInterval: 10 * time.Millisecond,
+		RetryBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond, MaxAttempts: 5,
+		DeadLetter: func(pk string, value interface{}, err error) {
+			dead <- deadLetter{pk: pk, value: value}
+		}}
+
+	c := cache.NewCache(&testtypes.TestProto{}, nil, store, res)
+	defer c.Close()
+	if err := c.SetWriteBehind(config); err != nil {
+		t.Fatalf("Failed to set write-behind: %s", err.Error())
+	}
+	pk1, _, _ := c.KeysFor(createModel(1))
+	store.setFailing(pk1)
+	for i := 1; i <= 5; i++ {
+		c.Post(createModel(i), false)
+	}
+
+	// the failing write does not hold back the writes of the other keys
+	select {
+	case letter := <-dead:
+		if letter.pk != pk1 || letter.value.(*testtypes.TestProto).MyInt32 != createModel(1).MyInt32 {
+			t.Errorf("Expected the write of %s to be dropped, got %s", pk1, letter.pk)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the failing write to be dropped after its attempts")
+	}
+	waitForWrites(c)
+	if c.PendingWrites() != 0 || store.size() != 4 {
+		t.Fatalf("Expected the 4 other elements in the store, got %d", store.size())
+	}
+
+	// a later write of the key is queued again
+	store.setFailing("")
+	c.Post(createModel(1), false)
+	waitForWrites(c)
+	if store.size() != 5 {
+		t.Errorf("Expected 5 elements in the store, got %d", store.size())
+	}
+}
+
+func TestCacheReconcile(t *testing.T) {
+	res := newResources()
+	store := newFailingStorage()
+	c := cache.NewCache(&testtypes.TestProto{}, nil, store, res)
+	defer c.Close()
+	for i := 1; i <= 5; i++ {
+		c.Post(createModel(i), false)
+	}
+
+	changed := createModel(1)
+	changed.MyInt32 = 500
+	pk1, _, _ := c.KeysFor(changed)
+	store.Put(pk1, changed)
+	pk2, _, _ := c.KeysFor(createModel(2))
+	store.Delete(pk2)
+	pk9, _, _ := c.KeysFor(createModel(9))
+	store.Put(pk9, createModel(9))
+
+	report, err := c.Reconcile()
+	if err != nil {
+		t.Fatalf("Failed to reconcile: %s", err.Error())
+	}
+	if len(report.Different) != 1 || report.Different[0] != pk1 {
+		t.Errorf("Expected %s to differ, got %v", pk1, report.Different)
+	}
+	if len(report.MissingInStore) != 1 || report.MissingInStore[0] != pk2 {
+		t.Errorf("Expected %s to be missing in the store, got %v", pk2, report.MissingInStore)
+	}
+	if len(report.MissingInCache) != 1 || report.MissingInCache[0] != pk9 {
+		t.Errorf("Expected %s to be missing in the cache, got %v", pk9, report.MissingInCache)
+	}
+}
+
+func TestCacheWriteBehindReplaysOnlyPendingWrites(t *testing.T) {
+	res := newResources()
+	store := newFailingStorage()
+	config := &cache.WriteBehindConfig{Dir: t.TempDir(), FlushInterval: time.Hour, MaxAttempts: 100}
+
+	c := cache.NewCache(&testtypes.TestProto{}, nil, store, res)
+	if err := c.SetWriteBehind(config); err != nil {
+		t.Fatalf("Failed to set write-behind: %s", err.Error())
+	}
+	pk1, _, _ := c.KeysFor(createModel(1))
+	store.setFailing(pk1)
+	for i := 1; i <= 5; i++ {
+		c.Post(createModel(i), false)
+	}
+	if err := c.FlushWrites(); err == nil {
+		t.Fatal("Expected the write of the failing key to fail")
+	}
+	if c.PendingWrites() != 1 || store.size() != 4 {
+		t.Fatalf("Expected 1 pending write and 4 elements in the store, got %d %d", c.PendingWrites(), store.size())
+	}
+	// the failed write stays in the outbox, as after a crash
+	c.Close()
+
+	store.setFailing("")
+	restarted := cache.NewCache(&testtypes.TestProto{}, nil, store, res)
+	defer restarted.Close()
+	if err := restarted.SetWriteBehind(config); err != nil {
+		t.Fatalf("Failed to replay the write-behind queue: %s", err.Error())
+	}
+	if restarted.PendingWrites() != 1 {
+		t.Fatalf("Expected only the failed write to be replayed, got %d", restarted.PendingWrites())
+	}
+}
+
+func TestCacheWriteBehindDisableWithPendingWrites(t *testing.T) {
+	res := newResources()
+	store := newFailingStorage()
+	config := &cache.WriteBehindConfig{Dir: t.TempDir(), FlushInterval: time.Hour}
+
+	c := cache.NewCache(&testtypes.TestProto{}, nil, store, res)
+	defer c.Close()
+	if err := c.SetWriteBehind(config); err != nil {
+		t.Fatalf("Failed to set write-behind: %s", err.Error())
+	}
+	store.setDown(true)
+	c.Post(createModel(1), false)
+	if err := c.SetWriteBehind(nil); err == nil {
+		t.Fatal("Expected disabling write-behind to fail while writes are pending")
+	}
+	if c.PendingWrites() != 1 {
+		t.Fatalf("Expected the write to stay pending, got %d", c.PendingWrites())
+	}
+
+	store.setDown(false)
+	if err := c.SetWriteBehind(nil); err != nil {
+		t.Fatalf("Failed to disable write-behind: %s", err.Error())
+	}
+	if store.size() != 1 {
+		t.Fatalf("Expected the pending write in the store, got %d", store.size())
+	}
+	// the writes are synchronous again
+	c.Post(createModel(2), false)
+	if store.size() != 2 {
+		t.Errorf("Expected a synchronous write to the store, got %d", store.size())
+	}
+}
+
+// batchStorage writes the batches of write-behind flushes in one call.
+type batchStorage struct {
+	*failingStorage
+	batches int
+}
+
+func (s *batchStorage) WriteBatch(keys []string, values []interface{}) error {
+	s.batches++
+	for i, key := range keys {
+		var err error
+		if values[i] == nil {
+			_, err = s.Delete(key)
+		} else {
+			err = s.Put(key, values[i])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestCacheWriteBehindBatchStorage(t *testing.T) {
+	res := newResources()
+	store := &batchStorage{failingStorage: newFailingStorage()}
+	config := &cache.WriteBehindConfig{Dir: t.TempDir(), FlushInterval: time.Hour}
+
+	c := cache.NewCache(&testtypes.TestProto{}, nil, store, res)
+	defer c.Close()
+	if err := c.SetWriteBehind(config); err != nil {
+		t.Fatalf("Failed to set write-behind: %s", err.Error())
+	}
+	for i := 1; i <= 5; i++ {
+		c.Post(createModel(i), false)
+	}
+	c.Delete(createModel(5), false)
+	if err := c.FlushWrites(); err != nil {
+		t.Fatalf("Failed to flush writes: %s", err.Error())
+	}
+	if store.batches != 1 {
+		t.Errorf("Expected the writes in one batch, got %d", store.batches)
+	}
+	if store.size() != 4 {
+		t.Errorf("Expected 4 elements in the store, got %d", store.size())
+	}
+}
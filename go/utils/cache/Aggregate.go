@@ -14,6 +14,8 @@
 package cache
 
 import (
+	"errors"
+
 	"github.com/saichler/l8types/go/ifs"
 	"github.com/saichler/l8types/go/types/l8api"
 )
@@ -21,26 +23,103 @@ import (
 // fetchAggregate handles aggregate queries by computing results in-memory.
 // It collects all cached objects, filters by WHERE, computes aggregates
 // (with GROUP BY), applies HAVING, and packs results into metadata.
-// Returns an empty slice and metadata with aggregate results in Counts.
-func (this *internalCache) fetchAggregate(q ifs.IQuery) ([]interface{}, *l8api.L8MetaData) {
+// Returns an empty slice and metadata with aggregate results in Counts,
+// with no results if the HAVING clause cannot be evaluated.
+func (this *internalCache) fetchAggregate(q ifs.IQuery, r ifs.IResources) ([]interface{}, *l8api.L8MetaData) {
+	metadata := newMetadata()
+	groups, err := this.aggregateGroups(q)
+	if err != nil {
+		if r != nil {
+			r.Logger().Error("Failed to fetch aggregate: ", err.Error())
+		}
+		return []interface{}{}, metadata
+	}
+
+	// Pack results into metadata
+	PackAggregateResults(groups, q.Aggregates(), q.GroupBy(), metadata)
+
+	return []interface{}{}, metadata
+}
+
+// aggregateGroups computes the groups of an aggregate query that pass its HAVING clause.
+// Queries registered with RegisterAggregate are served from their maintained groups.
+func (this *internalCache) aggregateGroups(q ifs.IQuery) ([]map[string]interface{}, error) {
+	if view, ok := this.aggregates[q.Hash()]; ok {
+		// the single key writes update the view concurrently
+		this.viewsMtx.Lock()
+		rows := view.rows()
+		this.viewsMtx.Unlock()
+		groups, err := view.buckets.finish(rows, q)
+		if err != nil {
+			return nil, err
+		}
+		return filterByHaving(groups, q.Having(), q.Aggregates())
+	}
+
 	// Collect all cached objects
-	items := make([]interface{}, 0, len(this.cache))
-	for _, v := range this.cache {
+	items := make([]interface{}, 0, this.size())
+	this.forEachQueried(q, func(pk string, v interface{}) {
 		items = append(items, v)
-	}
+	})
 
 	// Filter by WHERE criteria
 	filtered := q.Filter(items, false)
 
-	// Compute aggregates (handles GROUP BY internally)
-	groups := q.Aggregate(filtered)
+	// Compute aggregates (handles GROUP BY internally), grouping timestamps by their
+	// time buckets
+	buckets := this.bucketsOf(q)
+	var groups []map[string]interface{}
+	var err error
+	if buckets != nil {
+		groups, err = buckets.aggregate(q, filtered)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		groups = q.Aggregate(filtered)
+	}
+
+	// Compute the aggregate functions the query does not compute
+	this.addExtendedAggregates(q, filtered, groups, buckets)
+	groups, err = buckets.finish(groups, q)
+	if err != nil {
+		return nil, err
+	}
 
 	// Filter by HAVING clause
-	groups = filterByHaving(groups, q.Having())
+	return filterByHaving(groups, q.Having(), q.Aggregates())
+}
 
-	// Pack results into metadata
-	metadata := newMetadata()
-	PackAggregateResults(groups, q.Aggregates(), q.GroupBy(), metadata)
+// RegisterAggregate maintains the groups of the aggregate query incrementally on every
+// Post, Put, Patch and Delete, so a Fetch of the query does not scan the cache and
+// costs the number of groups. Supports the count, sum, avg, min and max functions, a
+// query with another function, such as the count_distinct, percentile, stddev and
+// histogram aggregates of the cache, is rejected and is computed on every Fetch.
+func (this *Cache) RegisterAggregate(q ifs.IQuery) error {
+	if !this.cacheEnabled() {
+		return errors.New("Aggregates are not maintained when the cache is disabled")
+	}
+	view, err := newAggregateView(q)
+	if err != nil {
+		return err
+	}
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	view.buckets = this.iCache.bucketsOf(q)
+	this.iCache.forEach(func(pk string, v interface{}) {
+		view.apply(pk, v)
+	})
+	if this.iCache.aggregates == nil {
+		this.iCache.aggregates = make(map[int32]*aggregateView)
+	}
+	this.iCache.aggregates[q.Hash()] = view
+	return nil
+}
 
-	return []interface{}{}, metadata
+// UnregisterAggregate stops maintaining the aggregate query, later fetches of it
+// compute it from the elements.
+func (this *Cache) UnregisterAggregate(q ifs.IQuery) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	delete(this.iCache.aggregates, q.Hash())
 }
@@ -0,0 +1,159 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/saichler/l8types/go/ifs"
+)
+
+// AggregateResultMode is how Fetch returns the results of aggregate queries.
+type AggregateResultMode int
+
+const (
+	// AggregatePacked packs the results into the metadata counts, keyed by alias and
+	// "alias:value1|value2" for grouped results. This is the default, for compatibility.
+	AggregatePacked AggregateResultMode = iota
+	// AggregateRows returns the results as *AggregateRow items, ordered by the query's
+	// sort clause, up to the query's limit and paged by start and blockSize, with the
+	// number of rows as the Total of the metadata. A HAVING clause that cannot be
+	// evaluated fails the fetch, which returns no rows and no metadata, use
+	// FetchAggregate to get its error.
+	AggregateRows
+)
+
+// AggregateRow is a group of an aggregate query result.
+type AggregateRow struct {
+	// GroupBy holds the values of the group by properties, in their original types.
+	GroupBy map[string]interface{}
+	// Aggregates holds the aggregate values by alias.
+	Aggregates map[string]interface{}
+}
+
+// SetAggregateResultMode sets how Fetch returns the results of aggregate queries.
+func (this *Cache) SetAggregateResultMode(mode AggregateResultMode) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.aggregateMode = mode
+}
+
+// FetchAggregate returns the groups of the aggregate query as rows, ordered by orderBy,
+// comma separated group by properties or aggregate aliases each optionally followed by
+// asc/desc and nulls first/last, or by the query's sort clause if orderBy is empty, up
+// to the query's limit. Fails if the HAVING clause cannot be evaluated.
+func (this *Cache) FetchAggregate(q ifs.IQuery, orderBy string) ([]*AggregateRow, error) {
+	if !q.IsAggregate() {
+		return nil, errors.New("Query is not an aggregate query")
+	}
+	descending := q.Descending()
+	if orderBy == "" {
+		orderBy = q.SortBy()
+	}
+	unlock := this.sharedLock()
+	defer unlock()
+	iCache, err := this.queryCache(q, true)
+	if err != nil {
+		return nil, err
+	}
+	rows, _, err := iCache.aggregateRows(q, orderBy, descending, 0, 0)
+	return rows, err
+}
+
+// aggregateRows returns the groups of the aggregate query as ordered rows, up to the
+// query's limit, from start and up to blockSize rows, 0 for all, and the number of rows
+// up to the limit.
+func (this *internalCache) aggregateRows(q ifs.IQuery, orderBy string, descending bool, start, blockSize int) ([]*AggregateRow, int, error) {
+	groups, err := this.aggregateGroups(q)
+	if err != nil {
+		return nil, 0, err
+	}
+	groupBy := q.GroupBy()
+	aggregates := q.Aggregates()
+	rows := make([]*AggregateRow, len(groups))
+	for i, group := range groups {
+		row := &AggregateRow{
+			GroupBy:    make(map[string]interface{}, len(groupBy)),
+			Aggregates: make(map[string]interface{}, len(aggregates)),
+		}
+		for _, field := range groupBy {
+			row.GroupBy[field] = group[field]
+		}
+		for _, agg := range aggregates {
+			if v, ok := group[agg.Alias]; ok {
+				row.Aggregates[agg.Alias] = v
+			}
+		}
+		rows[i] = row
+	}
+
+	keys, columns := parseRowSortKeys(orderBy, descending)
+	if len(keys) > 0 {
+		values := make([][]interface{}, len(rows))
+		for i, row := range rows {
+			values[i] = make([]interface{}, len(columns))
+			for j, column := range columns {
+				values[i][j] = sortable(row.value(column))
+			}
+		}
+		order := make([]int, len(rows))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return compareSortValues(keys, values[order[i]], values[order[j]]) < 0
+		})
+		sorted := make([]*AggregateRow, len(rows))
+		for i, o := range order {
+			sorted[i] = rows[o]
+		}
+		rows = sorted
+	}
+
+	if limit := int(q.Limit()); limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+	total := len(rows)
+	if start >= len(rows) {
+		return []*AggregateRow{}, total, nil
+	}
+	rows = rows[start:]
+	if blockSize > 0 && len(rows) > blockSize {
+		rows = rows[:blockSize]
+	}
+	return rows, total, nil
+}
+
+// value returns the group by or aggregate value of the column, matched case insensitively.
+func (this *AggregateRow) value(column string) interface{} {
+	if v, ok := this.GroupBy[column]; ok {
+		return v
+	}
+	if v, ok := this.Aggregates[column]; ok {
+		return v
+	}
+	for name, v := range this.GroupBy {
+		if strings.EqualFold(name, column) {
+			return v
+		}
+	}
+	for name, v := range this.Aggregates {
+		if strings.EqualFold(name, column) {
+			return v
+		}
+	}
+	return nil
+}
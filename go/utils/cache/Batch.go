@@ -0,0 +1,203 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/saichler/l8types/go/types/l8notify"
+)
+
+type batchAction int
+
+const (
+	batchPost batchAction = iota
+	batchPatch
+	batchDelete
+)
+
+type batchOp struct {
+	action batchAction
+	v      interface{}
+}
+
+// Batch is a list of mutations applied atomically by Cache.Batch.
+type Batch struct {
+	ops []batchOp
+}
+
+// NewBatch creates an empty batch.
+func NewBatch() *Batch {
+	return &Batch{ops: make([]batchOp, 0)}
+}
+
+// Post adds a Post of the element to the batch.
+func (this *Batch) Post(v interface{}) *Batch {
+	this.ops = append(this.ops, batchOp{action: batchPost, v: v})
+	return this
+}
+
+// Put adds a Put of the element to the batch.
+func (this *Batch) Put(v interface{}) *Batch {
+	return this.Post(v)
+}
+
+// Patch adds a Patch of the element to the batch.
+func (this *Batch) Patch(v interface{}) *Batch {
+	this.ops = append(this.ops, batchOp{action: batchPatch, v: v})
+	return this
+}
+
+// Delete adds a Delete of the element to the batch.
+func (this *Batch) Delete(v interface{}) *Batch {
+	this.ops = append(this.ops, batchOp{action: batchDelete, v: v})
+	return this
+}
+
+// Size returns the number of mutations in the batch.
+func (this *Batch) Size() int {
+	return len(this.ops)
+}
+
+// batchUndo is the state of an element before a batch mutation, to roll it back.
+type batchUndo struct {
+	pk       string
+	uk       string
+	prev     interface{}
+	existed  bool
+	expiry   int64
+	revision uint64
+}
+
+// Batch applies all the mutations of the batch under a single lock, so readers never
+// see part of the batch. If any mutation fails, the mutations already applied are rolled
+// back in the cache and the store and the error is returned. If createNotification is
+// true, the notification sets and the client notification sets of the mutations are
+// returned in the order the mutations were applied, with consecutive sequences, and are
+// expected to be delivered and applied in that order.
+func (this *Cache) Batch(batch *Batch, createNotification bool) ([]*l8notify.L8NotificationSet, []*l8notify.L8NotificationSet, error) {
+	if batch == nil || len(batch.ops) == 0 {
+		return nil, nil, nil
+	}
+
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.batchSequence.Add(1)
+	defer this.batchSequence.Add(1)
+
+	sequence := this.notifySequence.Load()
+	revision := this.iCache.revision.Load()
+	undo := make([]batchUndo, 0, len(batch.ops))
+	sets := make([]*l8notify.L8NotificationSet, 0, len(batch.ops))
+	clientSets := make([]*l8notify.L8NotificationSet, 0, len(batch.ops))
+	this.holdWatchEvents()
+
+	for i, op := range batch.ops {
+		pk, uk, err := this.KeysFor(op.v)
+		if err == nil && pk == "" {
+			err = errors.New("Interface does not contain the Key attributes")
+		}
+		if err == nil {
+			undo = append(undo, this.batchUndoOf(pk, uk))
+			var n, cn *l8notify.L8NotificationSet
+			switch op.action {
+			case batchPost:
+				n, cn, err = this.doPost(pk, uk, cloner.Clone(op.v), createNotification, 0)
+			case batchPatch:
+				n, cn, err = this.doPatch(pk, uk, op.v, createNotification)
+			case batchDelete:
+				n, cn, err = this.doDelete(pk, uk, createNotification)
+			}
+			if n != nil {
+				sets = append(sets, n)
+			}
+			if cn != nil {
+				clientSets = append(clientSets, cn)
+			}
+		}
+		if err != nil {
+			this.releaseWatchEvents(true)
+			this.rollback(undo, revision)
+			this.notifySequence.Store(sequence)
+			return nil, nil, errors.New("Batch operation " + strconv.Itoa(i) + " failed, batch rolled back: " + err.Error())
+		}
+	}
+
+	this.releaseWatchEvents(false)
+
+	if !createNotification || len(sets) == 0 {
+		return nil, nil, nil
+	}
+	if len(clientSets) == 0 {
+		return sets, nil, nil
+	}
+	if this.coalescer != nil {
+		this.coalescer.addBatch(clientSets)
+		return sets, nil, nil
+	}
+	return sets, clientSets, nil
+}
+
+func (this *Cache) batchUndoOf(pk, uk string) batchUndo {
+	u := batchUndo{pk: pk, uk: uk}
+	var prev interface{}
+	if this.cacheEnabled() {
+		prev, u.existed = this.iCache.value(pk)
+		u.uk = this.iCache.uniqueOf(pk)
+		u.expiry, _ = this.iCache.expiries.get(pk)
+		u.revision = this.iCache.revisionOf(pk)
+	} else if this.store != nil {
+		var err error
+		prev, err = this.readStore(pk)
+		u.existed = err == nil && prev != nil
+	}
+	if u.existed {
+		// Patch mutates the cached element in place
+		u.prev = cloner.Clone(prev)
+	}
+	return u
+}
+
+// rollback restores the elements of the applied batch mutations, latest first. The
+// elements are restored as they were, so the rolled back mutations leave no revisions
+// or history behind, revision being the cache revision before the batch.
+func (this *Cache) rollback(undo []batchUndo, revision uint64) {
+	for i := len(undo) - 1; i >= 0; i-- {
+		u := undo[i]
+		var err error
+		if this.cacheEnabled() && !this.iCache.restore(u.pk, u.uk, u.prev, u.revision, u.expiry, revision) {
+			// the failed mutation did not add the element, nothing to undo in the store
+			continue
+		}
+		if u.existed {
+			if this.store != nil {
+				err = this.storePut(u.pk, u.prev)
+			}
+			if err == nil {
+				err = this.walAppend(l8notify.L8NotificationType_Put, u.pk, u.prev)
+			}
+		} else {
+			if this.store != nil {
+				_, err = this.storeDelete(u.pk, nil)
+			}
+			if err == nil {
+				err = this.walAppend(l8notify.L8NotificationType_Delete, u.pk, nil)
+			}
+		}
+		if err != nil && this.r != nil {
+			this.r.Logger().Error("Failed to roll back batch mutation of ", u.pk, ": ", err.Error())
+		}
+	}
+}
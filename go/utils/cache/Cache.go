@@ -33,9 +33,12 @@ import (
 	"errors"
 	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/saichler/l8reflect/go/reflect/cloning"
 	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/l8types/go/types/l8notify"
 	"github.com/saichler/l8types/go/types/l8reflect"
 )
 
@@ -54,33 +57,64 @@ type Cache struct {
 	primaryKeyFieldNames []string
 	uniqueKeyFieldNames  []string
 	r                    ifs.IResources
+	elemType             reflect.Type
 
-	notifySequence uint32
+	// storeMtx serializes the store writes, unless concurrentStore, see ConcurrentStorage
+	storeMtx        *sync.RWMutex
+	concurrentStore bool
+	// storeWrites counts the store writes, which drop the kept scans of the store
+	storeWrites atomic.Uint64
+	scans       *storeScans
+
+	// notifySequence is the sequence of the next delta notification
+	notifySequence atomic.Uint32
 	serviceName    string
 	serviceArea    byte
 	cleaner        *ttlCleaner
 	subs           *subscriptions
+	bounded        atomic.Bool
+	// batchSequence is odd while a batch is applied, see Get
+	batchSequence atomic.Uint64
+	copyOnWrite   atomic.Bool
+
+	elementTTL     time.Duration
+	ttlField       *propertyPath
+	ttlFieldTTL    time.Duration
+	expiryListener func(*l8notify.L8NotificationSet, *l8notify.L8NotificationSet)
+
+	wal             *wal
+	writeBehind     *writeBehind
+	watchDispatcher *watchDispatcher
+	watchHeld       [][]*WatchEvent
+	coalescer       *coalescer
+	aggregateMode   AggregateResultMode
+	projectionMode  ProjectionMode
 }
 
 // NewCache creates a new Cache instance. The sampleElement is used to determine
 // the type and key field names for cached items. If initElements are provided and
 // the store is empty, they will be used to initialize the cache. The cache automatically
-// starts a TTL cleaner goroutine for query cache maintenance.
-func NewCache(sampleElement interface{}, initElements []interface{}, store ifs.IStorage, r ifs.IResources) *Cache {
-	this := &Cache{}
-	this.iCache = newInternalCache()
-	this.mtx = &sync.RWMutex{}
-	this.cond = sync.NewCond(this.mtx)
-	this.store = store
-	this.r = r
-	this.subs = newSubscriptions()
-	this.modelType = reflect.ValueOf(sampleElement).Elem().Type().Name()
-
-	_, _, err := this.KeysFor(sampleElement)
-	if err != nil {
-		panic("Error in initialized elements " + err.Error())
+// starts a TTL cleaner goroutine for query cache maintenance. The options, such as
+// WithWAL, are applied after the elements are loaded, an option that fails is logged
+// and not applied.
+func NewCache(sampleElement interface{}, initElements []interface{}, store ifs.IStorage, r ifs.IResources, options ...CacheOption) *Cache {
+	this := newCache(sampleElement, store, r)
+	this.load(initElements)
+	for _, option := range options {
+		if err := option(this); err != nil && r != nil {
+			r.Logger().Error("Failed to apply an option of cache ", this.modelType, ": ", err.Error())
+		}
 	}
+	this.start()
+	return this
+}
+
+// CacheOption configures a cache created by NewCache, after its elements are loaded.
+type CacheOption func(*Cache) error
 
+// load fills the cache from the store, or from the init elements when the store is empty.
+func (this *Cache) load(initElements []interface{}) {
+	r := this.r
 	loadedFromStore := false
 
 	if this.store != nil {
@@ -115,13 +149,38 @@ func NewCache(sampleElement interface{}, initElements []interface{}, store ifs.I
 			this.iCache.put(pk, uk, item)
 		}
 	}
+}
+
+func newCache(sampleElement interface{}, store ifs.IStorage, r ifs.IResources) *Cache {
+	this := &Cache{}
+	this.elemType = reflect.ValueOf(sampleElement).Elem().Type()
+	this.modelType = this.elemType.Name()
+	this.iCache = newInternalCache(this.modelType, this.elemType)
+	this.mtx = &sync.RWMutex{}
+	this.cond = sync.NewCond(this.mtx)
+	this.store = store
+	this.storeMtx = &sync.RWMutex{}
+	if cs, ok := store.(ConcurrentStorage); ok {
+		this.concurrentStore = cs.ConcurrentSafe()
+	}
+	this.r = r
+	this.subs = newSubscriptions()
+	this.scans = newStoreScans()
+
+	_, _, err := this.KeysFor(sampleElement)
+	if err != nil {
+		panic("Error in initialized elements " + err.Error())
+	}
+	return this
+}
+
+// start adds the default metadata and starts the TTL cleaner once the cache is loaded.
+func (this *Cache) start() {
 	addTotalMetadata(this)
 
 	// Start TTL cleaner for query cache
 	this.cleaner = newTTLCleaner(this)
 	this.cleaner.start()
-
-	return this
 }
 
 // SetNotificationsFor configures the cache to generate notifications for the specified
@@ -138,10 +197,77 @@ func (this *Cache) cacheEnabled() bool {
 	return this.store.CacheEnabled()
 }
 
+// ConcurrentStorage is implemented by stores that are safe for concurrent use. The
+// single key writes of different shards and the write-behind flushes run in parallel,
+// the cache calls a store that is ConcurrentSafe from all of them, and serializes its
+// calls to other stores: they are read concurrently, as before, but never written
+// concurrently nor read while written.
+type ConcurrentStorage interface {
+	ConcurrentSafe() bool
+}
+
+// lockStore locks the store for a read or a write, unless it is ConcurrentSafe.
+func (this *Cache) lockStore(write bool) func() {
+	if this.concurrentStore {
+		return func() {}
+	}
+	if write {
+		this.storeMtx.Lock()
+		return this.storeMtx.Unlock
+	}
+	this.storeMtx.RLock()
+	return this.storeMtx.RUnlock
+}
+
+// readStore reads an element from the store, see ConcurrentStorage.
+func (this *Cache) readStore(pk string) (interface{}, error) {
+	unlock := this.lockStore(false)
+	defer unlock()
+	return this.store.Get(pk)
+}
+
+// writeStore writes an element to the store, see ConcurrentStorage.
+func (this *Cache) writeStore(pk string, v interface{}) error {
+	unlock := this.lockStore(true)
+	defer unlock()
+	defer this.storeWrites.Add(1)
+	return this.store.Put(pk, v)
+}
+
+// deleteFromStore deletes an element from the store, see ConcurrentStorage.
+func (this *Cache) deleteFromStore(pk string) (interface{}, error) {
+	unlock := this.lockStore(true)
+	defer unlock()
+	defer this.storeWrites.Add(1)
+	return this.store.Delete(pk)
+}
+
+// collectStore collects the elements of the store, see ConcurrentStorage.
+func (this *Cache) collectStore(f func(interface{}) (bool, interface{})) map[string]interface{} {
+	unlock := this.lockStore(false)
+	defer unlock()
+	return this.store.Collect(f)
+}
+
+// writeStoreBatch writes a batch of elements to the store, see ConcurrentStorage.
+func (this *Cache) writeStoreBatch(bs BatchStorage, keys []string, values []interface{}) error {
+	unlock := this.lockStore(true)
+	defer unlock()
+	defer this.storeWrites.Add(1)
+	return bs.WriteBatch(keys, values)
+}
+
+// queryStore executes a query by the store, see ConcurrentStorage.
+func (this *Cache) queryStore(qs QueryStorage, q ifs.IQuery, start, limit int) ([]interface{}, int, error) {
+	unlock := this.lockStore(false)
+	defer unlock()
+	return qs.Query(q, start, limit)
+}
+
 // Size returns the number of items currently in the cache.
 func (this *Cache) Size() int {
-	this.mtx.RLock()
-	defer this.mtx.RUnlock()
+	unlock := this.sharedLock()
+	defer unlock()
 	return this.iCache.size()
 }
 
@@ -202,19 +328,46 @@ func (this *Cache) ModelType() string {
 
 // RegisterSubscription registers a user (identified by AAAId) for real-time
 // change notifications on this cache's model type. Called by the service
-// handler after Fetch when the query has Register=true.
+// handler after Fetch when the query has Register=true. Replaces the default
+// subscription of the AAAId, see RegisterSubscriptionFor for more than one.
 func (this *Cache) RegisterSubscription(aaaId string, queryHash int32, queryText string) {
+	this.RegisterSubscriptionFor(aaaId, "", queryHash, queryText, 0)
+}
+
+// RegisterSubscriptionFor registers a subscription of the AAAId identified by the
+// subscription id, e.g. a browser tab or a widget, replacing the one with the same ids.
+// ttlSeconds is how long it is kept without a refresh, 0 for DefaultSubscriptionTTL.
+func (this *Cache) RegisterSubscriptionFor(aaaId, subscriptionId string, queryHash int32, queryText string, ttlSeconds int64) {
 	this.subs.register(&Subscription{
-		AAAId:     aaaId,
-		QueryHash: queryHash,
-		QueryText: queryText,
+		AAAId:          aaaId,
+		SubscriptionId: subscriptionId,
+		QueryHash:      queryHash,
+		QueryText:      queryText,
+		TTL:            ttlSeconds,
 	})
 }
 
-// UnregisterSubscription removes a subscription for the given AAAId.
-// Called when a WebSocket disconnects or a user navigates away.
+// RefreshSubscription keeps the subscription alive for another TTL period.
+// Returns false if it does not exist, e.g. it was already evicted.
+func (this *Cache) RefreshSubscription(aaaId, subscriptionId string) bool {
+	return this.subs.refresh(aaaId, subscriptionId)
+}
+
+// UnregisterSubscription removes all the subscriptions of the given AAAId.
+// Called when a user logs out or all its WebSockets disconnect.
 func (this *Cache) UnregisterSubscription(aaaId string) {
-	this.subs.unregister(aaaId)
+	this.subs.unregisterAll(aaaId)
+}
+
+// UnregisterSubscriptionFor removes a single subscription of the AAAId.
+// Called when a WebSocket disconnects or a user navigates away.
+func (this *Cache) UnregisterSubscriptionFor(aaaId, subscriptionId string) {
+	this.subs.unregister(aaaId, subscriptionId)
+}
+
+// SubscriptionsOf returns copies of the subscriptions of the AAAId, sorted by subscription id.
+func (this *Cache) SubscriptionsOf(aaaId string) []*Subscription {
+	return this.subs.subscriptionsOf(aaaId)
 }
 
 // Subscribers returns all active subscriptions for this cache's model type.
@@ -234,10 +387,26 @@ func (this *Cache) EvictStaleSubscriptions(ttlSeconds int64) int {
 	return this.subs.evictStale(ttlSeconds)
 }
 
-// Close stops the TTL cleaner goroutine and releases cache resources.
-// This should be called when the cache is no longer needed.
+// Close stops the TTL cleaner goroutine, flushes the write-behind queue, closes the WAL
+// and releases cache resources. This should be called when the cache is no longer needed.
 func (this *Cache) Close() {
 	if this.cleaner != nil {
 		this.cleaner.stop()
 	}
+	if this.wal != nil {
+		this.wal.close()
+	}
+	if err := this.closeWriteBehind(); err != nil && this.r != nil {
+		this.r.Logger().Error("Failed to flush the write-behind queue of ", this.modelType, ": ", err.Error())
+	}
+	this.mtx.Lock()
+	if this.watchDispatcher != nil {
+		this.watchDispatcher.close()
+	}
+	coalescer := this.coalescer
+	this.coalescer = nil
+	this.mtx.Unlock()
+	if coalescer != nil {
+		coalescer.close()
+	}
 }
@@ -0,0 +1,215 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/saichler/l8types/go/types/l8notify"
+)
+
+// CoalesceClientNotifications holds the client notifications for the window instead of
+// returning them from the mutations, merging successive changes of the same key into one
+// notification, e.g. a Post followed by Patches is delivered as a single Post of the
+// latest element and a Post followed by a Delete is not delivered at all. At the end of
+// the window the notifications are delivered to the listener in change order, one per
+// call, or all in a single call if batchKeys is true. The notifications of a Batch are
+// delivered as they are, in a single call and in their place in the change order. A
+// window of 0 delivers the held notifications and returns to the uncoalesced behavior.
+func (this *Cache) CoalesceClientNotifications(window time.Duration, batchKeys bool, listener func([]*l8notify.L8NotificationSet)) {
+	this.mtx.Lock()
+	previous := this.coalescer
+	this.coalescer = nil
+	if window > 0 && listener != nil {
+		this.coalescer = newCoalescer(window, batchKeys, listener)
+	}
+	this.mtx.Unlock()
+	if previous != nil {
+		previous.close()
+	}
+}
+
+// coalesced hands the client notification of a mutation to the coalescer, if there is one.
+// Must be called with the cache write lock held.
+func (this *Cache) coalesced(n, cn *l8notify.L8NotificationSet, e error) (*l8notify.L8NotificationSet, *l8notify.L8NotificationSet, error) {
+	if cn != nil && this.coalescer != nil {
+		this.coalescer.add(cn)
+		cn = nil
+	}
+	return n, cn, e
+}
+
+// coalescedSlot is a place in the change order of a window, the merged notification of
+// a key or the notifications of a batch.
+type coalescedSlot struct {
+	sets []*l8notify.L8NotificationSet
+}
+
+// coalescer merges the client notifications of a window by key.
+type coalescer struct {
+	mtx       sync.Mutex
+	deliver   sync.Mutex
+	window    time.Duration
+	batchKeys bool
+	listener  func([]*l8notify.L8NotificationSet)
+	// pending are the open slots of the keys, order all the slots in change order
+	pending map[string]*coalescedSlot
+	order   []*coalescedSlot
+	timer   *time.Timer
+}
+
+func newCoalescer(window time.Duration, batchKeys bool, listener func([]*l8notify.L8NotificationSet)) *coalescer {
+	return &coalescer{
+		window:    window,
+		batchKeys: batchKeys,
+		listener:  listener,
+		pending:   make(map[string]*coalescedSlot),
+	}
+}
+
+func (this *coalescer) add(cn *l8notify.L8NotificationSet) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	key := cn.ModelKey
+	slot, ok := this.pending[key]
+	switch {
+	case !ok:
+		slot = &coalescedSlot{sets: []*l8notify.L8NotificationSet{cn}}
+		this.order = append(this.order, slot)
+		this.pending[key] = slot
+	default:
+		merged := mergeClientNotifications(slot.sets[0], cn)
+		if merged == nil {
+			// an element the subscribers never saw
+			delete(this.pending, key)
+			this.removeFromOrder(slot)
+		} else {
+			slot.sets[0] = merged
+		}
+	}
+	this.schedule()
+}
+
+// addBatch adds the client notifications of a batch, delivered as they are. The slots
+// pending before the batch are sealed, so later changes of their keys start new slots
+// after the batch instead of being delivered before it.
+func (this *coalescer) addBatch(sets []*l8notify.L8NotificationSet) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.order = append(this.order, &coalescedSlot{sets: sets})
+	this.pending = make(map[string]*coalescedSlot)
+	this.schedule()
+}
+
+// schedule starts the window if it is not started, with mtx held.
+func (this *coalescer) schedule() {
+	if this.timer == nil {
+		this.timer = time.AfterFunc(this.window, this.flush)
+	}
+}
+
+func (this *coalescer) removeFromOrder(slot *coalescedSlot) {
+	for i, s := range this.order {
+		if s == slot {
+			this.order = append(this.order[:i], this.order[i+1:]...)
+			return
+		}
+	}
+}
+
+// flush delivers the pending notifications, flushes are delivered one at a time so the
+// listener sees them in change order.
+func (this *coalescer) flush() {
+	this.deliver.Lock()
+	defer this.deliver.Unlock()
+
+	this.mtx.Lock()
+	order := this.order
+	this.pending = make(map[string]*coalescedSlot)
+	this.order = nil
+	this.timer = nil
+	this.mtx.Unlock()
+
+	if len(order) == 0 {
+		return
+	}
+	if this.batchKeys {
+		sets := make([]*l8notify.L8NotificationSet, 0, len(order))
+		for _, slot := range order {
+			sets = append(sets, slot.sets...)
+		}
+		this.listener(sets)
+		return
+	}
+	for _, slot := range order {
+		this.listener(slot.sets)
+	}
+}
+
+// close stops the window and delivers the pending notifications.
+func (this *coalescer) close() {
+	this.mtx.Lock()
+	if this.timer != nil {
+		this.timer.Stop()
+	}
+	this.mtx.Unlock()
+	this.flush()
+}
+
+// mergeClientNotifications merges the notification of a change into the pending one of
+// the same key, nil if the two cancel out.
+func mergeClientNotifications(first, next *l8notify.L8NotificationSet) *l8notify.L8NotificationSet {
+	var t l8notify.L8NotificationType
+	var old []byte
+	switch {
+	case first.Type == l8notify.L8NotificationType_Post && next.Type == l8notify.L8NotificationType_Delete:
+		return nil
+	case first.Type == l8notify.L8NotificationType_Post:
+		// still a new element for the subscribers
+		t = l8notify.L8NotificationType_Post
+	case first.Type == l8notify.L8NotificationType_Delete && next.Type != l8notify.L8NotificationType_Delete,
+		first.Type == l8notify.L8NotificationType_Put && next.Type != l8notify.L8NotificationType_Delete:
+		// replaced since the element the subscribers have
+		t = l8notify.L8NotificationType_Put
+		old = first.NotificationList[0].OldValue
+	default:
+		t = next.Type
+		if len(next.NotificationList) > 0 {
+			old = next.NotificationList[0].OldValue
+		}
+	}
+
+	merged := &l8notify.L8NotificationSet{}
+	merged.ServiceName = next.ServiceName
+	merged.ServiceArea = next.ServiceArea
+	merged.ModelType = next.ModelType
+	merged.ModelKey = next.ModelKey
+	merged.Type = t
+	merged.Source = next.Source
+	merged.Sequence = next.Sequence
+	merged.AaaIds = make(map[string]bool, len(first.AaaIds)+len(next.AaaIds))
+	for aaaId := range first.AaaIds {
+		merged.AaaIds[aaaId] = true
+	}
+	for aaaId := range next.AaaIds {
+		merged.AaaIds[aaaId] = true
+	}
+	n := &l8notify.L8Notification{OldValue: old}
+	if len(next.NotificationList) > 0 && t != l8notify.L8NotificationType_Delete {
+		n.NewValue = next.NotificationList[0].NewValue
+	}
+	merged.NotificationList = []*l8notify.L8Notification{n}
+	return merged
+}
@@ -14,22 +14,22 @@
 package cache
 
 // Collect iterates over all cached items and applies the filter function to each.
-// The filter function receives a cloned copy of each item and returns a boolean
+// The filter function receives a cloned copy of each item, or the item itself in copy
+// on write mode, and returns a boolean
 // indicating whether to include it and an optional transformed value.
 // Returns a map of primary keys to the filtered/transformed values.
 func (this *Cache) Collect(f func(interface{}) (bool, interface{})) map[string]interface{} {
 	result := map[string]interface{}{}
-	this.mtx.RLock()
-	defer this.mtx.RUnlock()
+	unlock := this.sharedLock()
+	defer unlock()
 	if this.cacheEnabled() {
-		for k, v := range this.iCache.cache {
-			vClone := cloner.Clone(v)
-			ok, elem := f(vClone)
+		this.iCache.forEach(func(k string, v interface{}) {
+			ok, elem := f(this.readCopy(v))
 			if ok {
 				result[k] = elem
 			}
-		}
+		})
 		return result
 	}
-	return this.store.Collect(f)
+	return this.collectStore(f)
 }
@@ -0,0 +1,47 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import "errors"
+
+// SetCopyOnWrite sets whether the cached elements are immutable snapshots. When enabled,
+// Patch and Put create a new version of an element instead of changing it in place, so
+// Get, GetWithRevision, Fetch, FetchCursor, Collect and the watch events return the
+// cached elements themselves instead of a copy of each. The returned elements are shared
+// with the cache and with other readers, and must not be modified by the caller.
+// Enabling it is a one way switch: readers may still hold shared elements, which in
+// place patches would change under them, so disabling it once enabled fails.
+func (this *Cache) SetCopyOnWrite(enabled bool) error {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	if !enabled && this.copyOnWrite.Load() {
+		return errors.New("Copy on write cannot be disabled, readers may hold the shared elements")
+	}
+	this.copyOnWrite.Store(enabled)
+	return nil
+}
+
+// CopyOnWrite returns true if the cached elements are shared with readers.
+func (this *Cache) CopyOnWrite() bool {
+	return this.copyOnWrite.Load()
+}
+
+// readCopy returns the element to give to a reader, the element itself when the
+// elements are immutable and a copy of it otherwise.
+func (this *Cache) readCopy(v interface{}) interface{} {
+	if this.copyOnWrite.Load() {
+		return v
+	}
+	return cloner.Clone(v)
+}
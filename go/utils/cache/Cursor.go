@@ -0,0 +1,234 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/l8types/go/types/l8api"
+)
+
+// fetchCursor is the continuation point of a cursor based fetch, the sort values
+// and primary key of the last returned row. Offset is the position of the next page
+// of a query executed by the store, see FetchAfter.
+type fetchCursor struct {
+	Hash   int32         `json:"h"`
+	Key    string        `json:"k"`
+	Values []cursorValue `json:"v,omitempty"`
+	Offset int           `json:"o,omitempty"`
+}
+
+// cursorValue is a single typed sort value, a zero Kind being a null value.
+type cursorValue struct {
+	Kind  uint   `json:"t,omitempty"`
+	Value string `json:"v,omitempty"`
+}
+
+// FetchAfter retrieves the next block of elements matching the query after the given
+// cursor, an empty cursor starting from the first element. Unlike Fetch, pages are
+// positioned by the sort value and primary key of the last row, so they stay stable
+// when elements are inserted or deleted between requests and do not depend on the
+// query still being cached. Returns the cloned elements, the cloned metadata of the
+// query and the cursor of the next page, which is empty when there are no more elements.
+// When the store has the cache disabled and is a QueryStorage that supports the query,
+// every page is executed by the store, which pages by position, so the cursor holds the
+// position of the next page and pages may shift when elements are inserted or deleted
+// between requests. Otherwise, with the cache disabled, the pages are evaluated on a
+// scan of the store made on the first page, see storeScans. Fails if the store fails to
+// execute the query.
+func (this *Cache) FetchAfter(cursor string, blockSize int, q ifs.IQuery) ([]interface{}, *l8api.L8MetaData, string, error) {
+	if q.IsAggregate() {
+		return nil, nil, "", errors.New("Cursor fetch is not supported for aggregate queries")
+	}
+	var fc *fetchCursor
+	if cursor != "" {
+		var err error
+		fc, err = decodeCursor(cursor)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		if fc.Hash != q.Hash() {
+			return nil, nil, "", errors.New("Cursor does not belong to this query")
+		}
+	}
+
+	unlock := this.sharedLock()
+	defer unlock()
+
+	if result, metadata, next, ok, err := this.fetchAfterFromStore(fc, blockSize, q); ok {
+		return result, metadata, next, err
+	}
+
+	iCache, err := this.queryCache(q, fc == nil)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	dq := iCache.prepared(q, this.r)
+	defer dq.mtx.Unlock()
+	if dq.err != nil {
+		return nil, nil, "", dq.err
+	}
+	start := 0
+	if fc != nil {
+		start = dq.after(fc.Key, fc.sortValues())
+	}
+	keys, values := iCache.pageWithKeys(dq, start, blockSize)
+
+	result := this.project(q, keys, values)
+
+	next := ""
+	if len(keys) > 0 && blockSize > 0 && start+len(keys) < len(dq.data) {
+		last := len(keys) - 1
+		next = encodeCursor(q.Hash(), keys[last], dq.sortValues(values[last]))
+	}
+
+	return result, cloner.Clone(dq.metadata).(*l8api.L8MetaData), next, nil
+}
+
+// fetchAfterFromStore executes the page of a cursor fetch by the store, returning false
+// if the store cannot execute the query, and the error of the store if it failed to.
+func (this *Cache) fetchAfterFromStore(fc *fetchCursor, blockSize int, q ifs.IQuery) ([]interface{}, *l8api.L8MetaData, string, bool, error) {
+	start := 0
+	if fc != nil {
+		start = fc.Offset
+	}
+	keys, values, metadata, ok, err := this.fetchFromStore(start, blockSize, q)
+	if !ok || err != nil {
+		return nil, nil, "", ok, err
+	}
+	result := this.project(q, keys, values)
+
+	next := ""
+	if len(keys) > 0 && blockSize > 0 && start+len(keys) < int(metadata.KeyCount.Counts[Total]) {
+		next = (&fetchCursor{Hash: q.Hash(), Key: keys[len(keys)-1], Offset: start + len(keys)}).encode()
+	}
+	return result, metadata, next, true, nil
+}
+
+func encodeCursor(hash int32, pk string, sortValues []interface{}) string {
+	fc := &fetchCursor{Hash: hash, Key: pk}
+	for _, sortValue := range sortValues {
+		fc.Values = append(fc.Values, encodeCursorValue(sortValue))
+	}
+	return fc.encode()
+}
+
+func (this *fetchCursor) encode() string {
+	data, _ := json.Marshal(this)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func encodeCursorValue(sortValue interface{}) cursorValue {
+	cv := cursorValue{}
+	if sortValue == nil {
+		return cv
+	}
+	v := reflect.ValueOf(sortValue)
+	cv.Kind = uint(v.Kind())
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		cv.Value = strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		cv.Value = strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		cv.Value = strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	case reflect.Bool:
+		cv.Value = strconv.FormatBool(v.Bool())
+	case reflect.String:
+		cv.Value = v.String()
+	default:
+		// not encodable, compared as null
+		cv.Kind = 0
+	}
+	return cv
+}
+
+func decodeCursor(cursor string) (*fetchCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errors.New("Invalid cursor: " + err.Error())
+	}
+	fc := &fetchCursor{}
+	err = json.Unmarshal(data, fc)
+	if err != nil {
+		return nil, errors.New("Invalid cursor: " + err.Error())
+	}
+	return fc, nil
+}
+
+// sortValues rebuilds the typed sort values of the cursor, nil for a cursor of an
+// unsorted query.
+func (this *fetchCursor) sortValues() []interface{} {
+	if len(this.Values) == 0 {
+		return nil
+	}
+	values := make([]interface{}, len(this.Values))
+	for i, cv := range this.Values {
+		values[i] = cv.value()
+	}
+	return values
+}
+
+// value rebuilds the sort value, of the same kind the query returned so it compares
+// with the elements sort values.
+func (this *cursorValue) value() interface{} {
+	if this.Kind == 0 {
+		return nil
+	}
+	switch reflect.Kind(this.Kind) {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(this.Value, 10, 64)
+		if err != nil {
+			return nil
+		}
+		return reflect.ValueOf(i).Convert(kindTypes[reflect.Kind(this.Kind)]).Interface()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(this.Value, 10, 64)
+		if err != nil {
+			return nil
+		}
+		return reflect.ValueOf(u).Convert(kindTypes[reflect.Kind(this.Kind)]).Interface()
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(this.Value, 64)
+		if err != nil {
+			return nil
+		}
+		return reflect.ValueOf(f).Convert(kindTypes[reflect.Kind(this.Kind)]).Interface()
+	case reflect.Bool:
+		return this.Value == "true"
+	case reflect.String:
+		return this.Value
+	}
+	return nil
+}
+
+var kindTypes = map[reflect.Kind]reflect.Type{
+	reflect.Int:     reflect.TypeOf(int(0)),
+	reflect.Int8:    reflect.TypeOf(int8(0)),
+	reflect.Int16:   reflect.TypeOf(int16(0)),
+	reflect.Int32:   reflect.TypeOf(int32(0)),
+	reflect.Int64:   reflect.TypeOf(int64(0)),
+	reflect.Uint:    reflect.TypeOf(uint(0)),
+	reflect.Uint8:   reflect.TypeOf(uint8(0)),
+	reflect.Uint16:  reflect.TypeOf(uint16(0)),
+	reflect.Uint32:  reflect.TypeOf(uint32(0)),
+	reflect.Uint64:  reflect.TypeOf(uint64(0)),
+	reflect.Float32: reflect.TypeOf(float32(0)),
+	reflect.Float64: reflect.TypeOf(float64(0)),
+}
@@ -31,9 +31,13 @@ func (this *Cache) Delete(v interface{}, createNotification bool) (*l8notify.L8N
 		return nil, nil, errors.New("Interface does not contain the Key attributes")
 	}
 
-	this.mtx.Lock()
-	defer this.mtx.Unlock()
+	unlock := this.writeLock(pk)
+	defer unlock()
+	return this.coalesced(this.doDelete(pk, uk, createNotification))
+}
 
+// doDelete implements Delete with the cache write lock of pk held.
+func (this *Cache) doDelete(pk, uk string, createNotification bool) (*l8notify.L8NotificationSet, *l8notify.L8NotificationSet, error) {
 	var n *l8notify.L8NotificationSet
 	var e error
 	var item interface{}
@@ -47,16 +51,21 @@ func (this *Cache) Delete(v interface{}, createNotification bool) (*l8notify.L8N
 	}
 
 	if this.store != nil {
-		item, e = this.store.Delete(pk)
+		item, e = this.storeDelete(pk, item)
 		if e != nil {
 			return n, nil, e
 		}
 	}
+	e = this.walAppend(l8notify.L8NotificationType_Delete, pk, nil)
+	if e != nil {
+		return n, nil, e
+	}
+	affected := this.watchAfter(this.watchBefore(item), pk, item, nil)
 
 	if !createNotification {
 		return n, nil, e
 	}
 
 	n, e = this.createDeleteNotification(item, pk)
-	return n, this.createClientNotification(n), e
+	return n, this.createClientNotification(n, affected), e
 }
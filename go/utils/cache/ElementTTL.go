@@ -0,0 +1,150 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"time"
+
+	"github.com/saichler/l8types/go/types/l8notify"
+)
+
+// Epoch values above this are treated as milliseconds rather than seconds.
+const epochMillisThreshold = 100000000000
+
+// SetElementTTL sets the default time-to-live of elements added or replaced by
+// Post and Put, zero disables expiry. Elements already in the cache without an
+// expiry get one as well. Expired elements are deleted by the TTL cleaner.
+func (this *Cache) SetElementTTL(ttl time.Duration) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.elementTTL = ttl
+	this.setMissingExpiries()
+}
+
+// SetElementTTLField derives the expiry of elements from an epoch timestamp property
+// (seconds or milliseconds) plus the given ttl, e.g. the sample time of a telemetry entry.
+// The expiry is re-evaluated when a Patch changes the element.
+func (this *Cache) SetElementTTLField(propertyPath string, ttl time.Duration) error {
+	property, err := newPropertyPath(this.elemType, propertyPath)
+	if err != nil {
+		return err
+	}
+	if !isNumericKind(property.kind()) {
+		return errors.New("TTL property " + propertyPath + " is not numeric")
+	}
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.ttlField = property
+	this.ttlFieldTTL = ttl
+	this.setMissingExpiries()
+	return nil
+}
+
+func (this *Cache) setMissingExpiries() {
+	this.iCache.forEach(func(pk string, v interface{}) {
+		if _, ok := this.iCache.expiries.get(pk); !ok {
+			this.iCache.expiries.set(pk, this.expiryOf(v, 0))
+		}
+	})
+}
+
+// PostWithTTL adds or replaces an element like Post, expiring it after the given ttl
+// regardless of the default element TTL.
+func (this *Cache) PostWithTTL(v interface{}, ttl time.Duration, createNotification bool) (*l8notify.L8NotificationSet, *l8notify.L8NotificationSet, error) {
+	return this.post(v, createNotification, ttl)
+}
+
+// OnElementExpired sets a listener that receives the Delete notification (and the
+// client notification when there are subscribers) of every expired element, so the
+// expiry can be propagated to remote replicas and clients.
+func (this *Cache) OnElementExpired(listener func(*l8notify.L8NotificationSet, *l8notify.L8NotificationSet)) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.expiryListener = listener
+}
+
+// ExpireElementsNow deletes the elements whose TTL has passed, returning how many
+// were expired. Used for testing; in production the TTL cleaner calls this automatically.
+func (this *Cache) ExpireElementsNow() int {
+	return this.expireElements(time.Now().UnixNano())
+}
+
+// expiryOf returns the expiry time (unix nano) of an element, zero for no expiry.
+func (this *Cache) expiryOf(v interface{}, ttl time.Duration) int64 {
+	if ttl > 0 {
+		return time.Now().Add(ttl).UnixNano()
+	}
+	if this.ttlField != nil {
+		if value, ok := this.ttlField.valueOf(v); ok {
+			if epoch, ok := ToFloat64(value); ok && epoch > 0 {
+				stamp := time.Unix(int64(epoch), 0)
+				if epoch > epochMillisThreshold {
+					stamp = time.UnixMilli(int64(epoch))
+				}
+				return stamp.Add(this.ttlFieldTTL).UnixNano()
+			}
+		}
+	}
+	if this.elementTTL > 0 {
+		return time.Now().Add(this.elementTTL).UnixNano()
+	}
+	return 0
+}
+
+func (this *Cache) expireElements(now int64) int {
+	type expired struct {
+		delta  *l8notify.L8NotificationSet
+		client *l8notify.L8NotificationSet
+	}
+	notifications := make([]expired, 0)
+
+	this.mtx.Lock()
+	listener := this.expiryListener
+	removed := 0
+	for _, pk := range this.iCache.expiries.due(now) {
+		item, ok := this.iCache.delete(pk, "")
+		if !ok {
+			continue
+		}
+		removed++
+		if this.store != nil {
+			if _, e := this.storeDelete(pk, item); e != nil && this.r != nil {
+				this.r.Logger().Error("Failed to delete expired element ", pk, " from store: ", e.Error())
+			}
+		}
+		if e := this.walAppend(l8notify.L8NotificationType_Delete, pk, nil); e != nil && this.r != nil {
+			this.r.Logger().Error("Failed to log expired element ", pk, " in WAL: ", e.Error())
+		}
+		affected := this.watchAfter(this.watchBefore(item), pk, item, nil)
+		if listener == nil {
+			continue
+		}
+		n, e := this.createDeleteNotification(item, pk)
+		if e != nil {
+			if this.r != nil {
+				this.r.Logger().Error("Failed to create expiry notification for ", pk, ": ", e.Error())
+			}
+			continue
+		}
+		_, cn, _ := this.coalesced(n, this.createClientNotification(n, affected), nil)
+		notifications = append(notifications, expired{delta: n, client: cn})
+	}
+	this.mtx.Unlock()
+
+	for _, n := range notifications {
+		listener(n.delta, n.client)
+	}
+	return removed
+}
@@ -0,0 +1,184 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+
+	"github.com/saichler/l8types/go/ifs"
+)
+
+// EvictionPolicy selects which resident element is evicted when a bounded cache
+// is over capacity.
+type EvictionPolicy int
+
+const (
+	// EvictNone keeps every element in memory (the default).
+	EvictNone EvictionPolicy = iota
+	// EvictLRU evicts the least recently used element.
+	EvictLRU
+	// EvictLFU evicts the least frequently used element.
+	EvictLFU
+)
+
+// SetEviction bounds the number of elements (maxEntries) and/or their estimated size
+// in bytes (maxBytes) kept in memory, zero meaning no limit. Evicted elements remain
+// in the store and are transparently loaded back by Get and Collect. Queries are
+// evaluated over the resident elements, and over the evicted ones by the store when
+// it is a QueryStorage that supports the query, otherwise the evicted elements are
+// left out of their results. Requires a store with caching enabled. EvictNone removes
+// the bound.
+func (this *Cache) SetEviction(policy EvictionPolicy, maxEntries int, maxBytes int64) error {
+	if policy != EvictNone {
+		if this.store == nil || !this.store.CacheEnabled() {
+			return errors.New("Eviction requires a store with cache enabled")
+		}
+		if maxEntries <= 0 && maxBytes <= 0 {
+			return errors.New("Eviction requires maxEntries or maxBytes")
+		}
+	}
+
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+
+	var e *eviction
+	switch policy {
+	case EvictNone:
+	case EvictLRU:
+		e = &eviction{policy: newLRUEvictor()}
+	case EvictLFU:
+		e = &eviction{policy: newLFUEvictor()}
+	default:
+		return errors.New("Unknown eviction policy")
+	}
+	if e != nil {
+		e.maxEntries = maxEntries
+		e.maxBytes = maxBytes
+		e.sizes = make(map[string]int64)
+		e.loader = this.loadFromStore
+		e.query = this.queryEvicted
+	}
+	this.iCache.setEviction(e)
+	this.bounded.Store(e != nil)
+	return nil
+}
+
+// ResidentSize returns the number of elements currently held in memory, which is
+// less than Size when elements were evicted.
+func (this *Cache) ResidentSize() int {
+	unlock := this.sharedLock()
+	defer unlock()
+	return int(this.iCache.residents.Load())
+}
+
+func (this *Cache) loadFromStore(pk string) (interface{}, bool) {
+	// the store is behind the queued writes
+	if this.writeBehind != nil {
+		if item, ok := this.writeBehind.pendingValue(pk); ok {
+			return cloner.Clone(item), item != nil
+		}
+	}
+	item, err := this.readStore(pk)
+	if err != nil || item == nil {
+		return nil, false
+	}
+	return item, true
+}
+
+// queryEvicted returns the elements of the store matching the query by primary key,
+// nil if the store cannot execute the query. Elements with queued writes are returned
+// as queued.
+func (this *Cache) queryEvicted(q ifs.IQuery) map[string]interface{} {
+	qs, ok := this.store.(QueryStorage)
+	if !ok || !qs.SupportsQuery(q) {
+		return nil
+	}
+	elements, _, err := this.queryStore(qs, q, 0, 0)
+	if err != nil {
+		this.logQueryError(err)
+		return nil
+	}
+	result := make(map[string]interface{}, len(elements))
+	for _, v := range elements {
+		pk, _, err := this.KeysFor(v)
+		if err != nil {
+			this.logQueryError(err)
+			continue
+		}
+		if this.writeBehind != nil {
+			if item, ok := this.writeBehind.pendingValue(pk); ok {
+				if item == nil {
+					continue
+				}
+				v = cloner.Clone(item)
+			}
+		}
+		result[pk] = v
+	}
+	return result
+}
+
+// readLock locks the cache for a read that may reload evicted elements,
+// which requires the exclusive lock when the cache is bounded.
+func (this *Cache) readLock() func() {
+	if !this.bounded.Load() {
+		this.mtx.RLock()
+		if !this.bounded.Load() {
+			return this.mtx.RUnlock
+		}
+		this.mtx.RUnlock()
+	}
+	this.mtx.Lock()
+	return this.mtx.Unlock
+}
+
+// sharedLock locks the cache for a read of the elements, keys, indexes, queries and
+// aggregates, shared with the other reads and with the single key writes, which only
+// hold the locks of the shard and of the views they change.
+func (this *Cache) sharedLock() func() {
+	this.mtx.RLock()
+	return this.mtx.RUnlock
+}
+
+// writeLock locks the cache for a write of the element pk. When the cache holds all the
+// elements and is not bounded, the write holds the cache read lock and the writer lock
+// of the element's shard, so writes of keys of different shards and reads run in
+// parallel. Otherwise the write takes the exclusive lock.
+func (this *Cache) writeLock(pk string) func() {
+	if this.cacheEnabled() && !this.bounded.Load() {
+		this.mtx.RLock()
+		if !this.bounded.Load() {
+			writer := this.iCache.shardOf(pk).writer
+			writer.Lock()
+			return func() {
+				writer.Unlock()
+				this.mtx.RUnlock()
+			}
+		}
+		this.mtx.RUnlock()
+	}
+	this.mtx.Lock()
+	return this.mtx.Unlock
+}
+
+// patchLock locks the cache for a patch of the element pk. Without copy on write the
+// element is changed in place, which the reads must not see in part, so the patch takes
+// the exclusive lock. Copy on write is never disabled once enabled.
+func (this *Cache) patchLock(pk string) func() {
+	if this.copyOnWrite.Load() {
+		return this.writeLock(pk)
+	}
+	this.mtx.Lock()
+	return this.mtx.Unlock
+}
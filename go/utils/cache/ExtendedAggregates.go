@@ -0,0 +1,288 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/l8types/go/types/l8api"
+)
+
+// defaultHistogramBuckets is the number of equal width buckets of a histogram
+// without bounds set by SetHistogramBounds.
+const defaultHistogramBuckets = 10
+
+// HistogramBucket is a bucket of a histogram aggregate, counting the values that are
+// less than or equal to its upper bound and greater than the previous bucket's.
+type HistogramBucket struct {
+	UpperBound float64
+	Count      int
+}
+
+// SetHistogramBounds sets the upper bounds of the buckets of the histogram aggregate
+// with the alias, a last bucket up to +Inf is added. Without bounds the histogram has
+// equal width buckets between the minimum and maximum of each group.
+func (this *Cache) SetHistogramBounds(alias string, bounds []float64) {
+	sorted := append([]float64(nil), bounds...)
+	sort.Float64s(sorted)
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	if this.iCache.histogramBounds == nil {
+		this.iCache.histogramBounds = make(map[string][]float64)
+	}
+	this.iCache.histogramBounds[alias] = sorted
+}
+
+type extendedKind int
+
+const (
+	extendedCountDistinct extendedKind = iota
+	extendedPercentile
+	extendedStdDev
+	extendedStdDevSample
+	extendedHistogram
+)
+
+// extendedAggregate is an aggregate function the query does not compute, it is
+// computed by the cache from the property values of each group.
+type extendedAggregate struct {
+	alias      string
+	kind       extendedKind
+	percentile float64
+	property   *propertyPath
+}
+
+// extendedKindOf parses the aggregate functions computed by the cache: count_distinct,
+// p<N> percentiles such as p50, p95 or p99.9, stddev, stddev_samp and histogram.
+func extendedKindOf(function string) (extendedKind, float64, bool) {
+	function = strings.ToLower(strings.TrimSpace(function))
+	switch function {
+	case "count_distinct", "countdistinct", "distinct_count":
+		return extendedCountDistinct, 0, true
+	case "stddev", "stddev_pop":
+		return extendedStdDev, 0, true
+	case "stddev_samp":
+		return extendedStdDevSample, 0, true
+	case "histogram":
+		return extendedHistogram, 0, true
+	}
+	if strings.HasPrefix(function, "p") {
+		p, err := strconv.ParseFloat(function[1:], 64)
+		if err == nil && p > 0 && p <= 100 {
+			return extendedPercentile, p, true
+		}
+	}
+	return 0, 0, false
+}
+
+func (this *internalCache) extendedAggregates(aggregates []*l8api.L8AggregateFunction) []*extendedAggregate {
+	var result []*extendedAggregate
+	for _, agg := range aggregates {
+		kind, percentile, ok := extendedKindOf(agg.Function)
+		if !ok {
+			continue
+		}
+		property, err := newPropertyPath(this.elemType, normalizePropertyName(agg.Property, this.modelType))
+		if err != nil {
+			continue
+		}
+		result = append(result, &extendedAggregate{alias: agg.Alias, kind: kind, percentile: percentile, property: property})
+	}
+	return result
+}
+
+// addExtendedAggregates computes the extended aggregates of the query into its groups,
+// items are the elements that passed the WHERE clause and buckets the time buckets of
+// the groups, if any.
+func (this *internalCache) addExtendedAggregates(q ifs.IQuery, items []interface{}, groups []map[string]interface{}, buckets groupBuckets) {
+	extended := this.extendedAggregates(q.Aggregates())
+	if len(extended) == 0 || len(groups) == 0 {
+		return
+	}
+	groupBy := q.GroupBy()
+	byKey := make(map[string]map[string]interface{}, len(groups))
+	for _, group := range groups {
+		byKey[groupKeyOf(group, groupBy)] = group
+	}
+	paths := this.groupByPaths(groupBy)
+
+	// the values of each extended aggregate, by group key
+	values := make(map[string][][]interface{}, len(groups))
+	keyValues := make(map[string]interface{}, len(groupBy))
+	for _, item := range items {
+		key := ""
+		if len(groupBy) > 0 {
+			var resolved bool
+			key, resolved = this.groupKeyOfItem(item, groupBy, paths, buckets, keyValues)
+			if _, found := byKey[key]; !resolved || !found {
+				// not a plain property value, group the element as the query does
+				rows := q.Aggregate([]interface{}{item})
+				if len(rows) == 0 {
+					continue
+				}
+				buckets.bucketRow(rows[0])
+				key = groupKeyOf(rows[0], groupBy)
+			}
+		}
+		groupValues, ok := values[key]
+		if !ok {
+			groupValues = make([][]interface{}, len(extended))
+			values[key] = groupValues
+		}
+		for i, ext := range extended {
+			if v, ok := ext.property.valueOf(item); ok && v != nil {
+				groupValues[i] = append(groupValues[i], v)
+			}
+		}
+	}
+
+	for key, group := range byKey {
+		groupValues := values[key]
+		for i, ext := range extended {
+			var vs []interface{}
+			if groupValues != nil {
+				vs = groupValues[i]
+			}
+			if v, ok := this.computeExtended(ext, vs); ok {
+				group[ext.alias] = v
+			}
+		}
+	}
+}
+
+// groupByPaths resolves the group by properties, nil if one of them is not a property
+// of the model.
+func (this *internalCache) groupByPaths(groupBy []string) []*propertyPath {
+	paths := make([]*propertyPath, len(groupBy))
+	for i, field := range groupBy {
+		path, err := newPropertyPath(this.elemType, normalizePropertyName(field, this.modelType))
+		if err != nil {
+			return nil
+		}
+		paths[i] = path
+	}
+	return paths
+}
+
+// groupKeyOfItem returns the group key of the element from its group by property
+// values, timestamps replaced by their buckets, the key of its row in the query's
+// groups, keyValues being reused between the elements.
+func (this *internalCache) groupKeyOfItem(item interface{}, groupBy []string, paths []*propertyPath, buckets groupBuckets, keyValues map[string]interface{}) (string, bool) {
+	if paths == nil {
+		return "", false
+	}
+	for i, path := range paths {
+		v, _ := path.valueOf(item)
+		keyValues[groupBy[i]] = v
+	}
+	buckets.bucketRow(keyValues)
+	return groupKeyOf(keyValues, groupBy), true
+}
+
+func (this *internalCache) computeExtended(ext *extendedAggregate, values []interface{}) (interface{}, bool) {
+	if ext.kind == extendedCountDistinct {
+		distinct := make(map[string]bool, len(values))
+		for _, v := range values {
+			distinct[fmt.Sprintf("%v", v)] = true
+		}
+		return float64(len(distinct)), true
+	}
+
+	numbers := make([]float64, 0, len(values))
+	for _, v := range values {
+		if f, ok := ToFloat64(sortable(v)); ok {
+			numbers = append(numbers, f)
+		}
+	}
+	if len(numbers) == 0 {
+		return nil, false
+	}
+	sort.Float64s(numbers)
+
+	switch ext.kind {
+	case extendedPercentile:
+		return percentileOf(numbers, ext.percentile), true
+	case extendedStdDev, extendedStdDevSample:
+		return stdDevOf(numbers, ext.kind == extendedStdDevSample)
+	case extendedHistogram:
+		return histogramOf(numbers, this.histogramBounds[ext.alias]), true
+	}
+	return nil, false
+}
+
+// percentileOf interpolates the p percentile of the sorted values between the two
+// closest ranks.
+func percentileOf(sorted []float64, p float64) float64 {
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	if lower == upper {
+		return sorted[lower]
+	}
+	return sorted[lower] + (rank-float64(lower))*(sorted[upper]-sorted[lower])
+}
+
+// stdDevOf returns the population standard deviation of the values, or the sample
+// standard deviation that needs at least two values.
+func stdDevOf(values []float64, sample bool) (float64, bool) {
+	n := float64(len(values))
+	if sample {
+		n--
+	}
+	if n <= 0 {
+		return 0, false
+	}
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(variance / n), true
+}
+
+// histogramOf counts the sorted values into the buckets of the bounds, or into equal
+// width buckets between the minimum and the maximum if there are no bounds.
+func histogramOf(sorted []float64, bounds []float64) []HistogramBucket {
+	if len(bounds) > 0 {
+		bounds = append(append([]float64(nil), bounds...), math.Inf(1))
+	} else if min, max := sorted[0], sorted[len(sorted)-1]; min == max {
+		bounds = []float64{max}
+	} else {
+		bounds = make([]float64, defaultHistogramBuckets)
+		for i := range bounds {
+			bounds[i] = min + (max-min)*float64(i+1)/defaultHistogramBuckets
+		}
+		// exactly the maximum, regardless of rounding
+		bounds[len(bounds)-1] = max
+	}
+	buckets := make([]HistogramBucket, len(bounds))
+	i := 0
+	for b, bound := range bounds {
+		buckets[b].UpperBound = bound
+		for i < len(sorted) && sorted[i] <= bound {
+			buckets[b].Count++
+			i++
+		}
+	}
+	return buckets
+}
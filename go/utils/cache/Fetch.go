@@ -20,23 +20,64 @@ import (
 
 // Fetch retrieves a paginated slice of items from the cache matching the query criteria.
 // The start parameter specifies the starting index and blockSize determines the page size.
-// Results are cloned to prevent external mutation. Metadata is returned only on the first page.
+// Results are cloned to prevent external mutation, unless in copy on write mode.
+// Metadata is returned only on the first page.
 // Query results may be cached internally with TTL-based expiration for performance.
+// Fetches hold the cache read lock, so they run concurrently with each other.
+// Aggregate query results are returned as set by SetAggregateResultMode, and the elements
+// of queries selecting properties as set by SetProjectionMode.
+// When the store has the cache disabled, queries are executed by the store if it is a
+// QueryStorage that supports them, and are evaluated on a scan of the store otherwise.
+// The metadata of a query executed by the store holds only the Total count, the
+// metadata functions are not evaluated on its elements. A scan of the store is made on
+// the first page and reused by the following ones, see storeScans. A query the store
+// fails to execute is logged and fetches nothing.
 func (this *Cache) Fetch(start, blockSize int, q ifs.IQuery) ([]interface{}, *l8api.L8MetaData) {
-	this.mtx.Lock()
-	defer this.mtx.Unlock()
-	values, metadata := this.iCache.fetch(start, blockSize, q, this.r)
+	unlock := this.sharedLock()
+	defer unlock()
+	keys, values, metadata, ok, err := this.fetchFromStore(start, blockSize, q)
+	if err == nil && ok {
+		return this.fetched(q, keys, values, metadata)
+	}
+	var iCache *internalCache
+	if err == nil {
+		iCache, err = this.queryCache(q, q.Page() == 0)
+	}
+	if err != nil {
+		if this.r != nil {
+			this.r.Logger().Error("Failed to fetch ", this.modelType, ": ", err.Error())
+		}
+		return nil, nil
+	}
+	if this.aggregateMode == AggregateRows && q.IsAggregate() {
+		rows, total, err := iCache.aggregateRows(q, q.SortBy(), q.Descending(), start, blockSize)
+		if err != nil {
+			if this.r != nil {
+				this.r.Logger().Error("Failed to fetch aggregate: ", err.Error())
+			}
+			return nil, nil
+		}
+		result := make([]interface{}, len(rows))
+		for i, row := range rows {
+			result[i] = row
+		}
+		metadata = newMetadata()
+		metadata.KeyCount.Counts[Total] = float64(total)
+		return result, metadata
+	}
+	keys, values, metadata = iCache.fetch(start, blockSize, q, this.r)
+	return this.fetched(q, keys, values, metadata)
+}
 
+// fetched returns the fetched page of elements and its metadata to the caller.
+func (this *Cache) fetched(q ifs.IQuery, keys []string, values []interface{}, metadata *l8api.L8MetaData) ([]interface{}, *l8api.L8MetaData) {
 	// Aggregate queries return empty slice with results in metadata
 	if q.IsAggregate() {
 		metadataClone := cloner.Clone(metadata).(*l8api.L8MetaData)
 		return values, metadataClone
 	}
 
-	result := make([]interface{}, len(values))
-	for i, v := range values {
-		result[i] = cloner.Clone(v)
-	}
+	result := this.project(q, keys, values)
 
 	if q.Page() == 0 {
 		metadataClone := cloner.Clone(metadata).(*l8api.L8MetaData)
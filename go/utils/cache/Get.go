@@ -39,17 +39,29 @@ func (this *Cache) Get(v interface{}) (interface{}, error) {
 		return item, e
 	}
 
-	this.mtx.RLock()
-	defer this.mtx.RUnlock()
+	if this.cacheEnabled() && !this.bounded.Load() {
+		// Read the element with its shard lock only, so Get is not blocked by writes
+		// of other keys and by fetches. A batch being applied is read with the cache
+		// lock, so it is never seen in part.
+		sequence := this.batchSequence.Load()
+		if sequence%2 == 0 {
+			item, ok = this.iCache.residentCopy(pk, uk, this.copyOnWrite.Load())
+			if ok && this.batchSequence.Load() == sequence {
+				return item, e
+			}
+		}
+	}
+
+	unlock := this.readLock()
+	defer unlock()
 
 	if this.cacheEnabled() {
 		item, ok = this.iCache.get(pk, uk)
 		if ok {
-			itemClone := cloner.Clone(item)
-			return itemClone, e
+			return this.readCopy(item), e
 		}
 	} else {
-		item, e = this.store.Get(pk)
+		item, e = this.readStore(pk)
 		if e == nil {
 			return item, e
 		}
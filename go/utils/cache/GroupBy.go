@@ -16,7 +16,9 @@ package cache
 import (
 	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/saichler/l8types/go/types/l8api"
 )
@@ -30,9 +32,7 @@ func PackAggregateResults(groups []map[string]interface{}, aggregates []*l8api.L
 		// Single group — flat keys
 		if len(groups) == 1 {
 			for _, agg := range aggregates {
-				if val, ok := ToFloat64(groups[0][agg.Alias]); ok {
-					metadata.KeyCount.Counts[agg.Alias] = val
-				}
+				packAggregateValue(agg.Alias, "", groups[0][agg.Alias], metadata)
 			}
 		}
 		return
@@ -42,11 +42,23 @@ func PackAggregateResults(groups []map[string]interface{}, aggregates []*l8api.L
 	for _, group := range groups {
 		groupKey := BuildGroupKeyString(group, groupByFields)
 		for _, agg := range aggregates {
-			key := agg.Alias + ":" + groupKey
-			if val, ok := ToFloat64(group[agg.Alias]); ok {
-				metadata.KeyCount.Counts[key] = val
-			}
+			packAggregateValue(agg.Alias, ":"+groupKey, group[agg.Alias], metadata)
+		}
+	}
+}
+
+// packAggregateValue packs a value of an aggregate under alias+suffix, histograms are
+// packed one key per bucket, alias[upperBound]+suffix.
+func packAggregateValue(alias, suffix string, value interface{}, metadata *l8api.L8MetaData) {
+	if buckets, ok := value.([]HistogramBucket); ok {
+		for _, bucket := range buckets {
+			key := alias + "[" + strconv.FormatFloat(bucket.UpperBound, 'g', -1, 64) + "]" + suffix
+			metadata.KeyCount.Counts[key] = float64(bucket.Count)
 		}
+		return
+	}
+	if val, ok := ToFloat64(value); ok {
+		metadata.KeyCount.Counts[alias+suffix] = val
 	}
 }
 
@@ -56,7 +68,9 @@ func BuildGroupKeyString(group map[string]interface{}, groupByFields []string) s
 	parts := make([]string, 0, len(groupByFields))
 	for _, field := range groupByFields {
 		val := group[field]
-		if val != nil {
+		if t, ok := val.(time.Time); ok {
+			parts = append(parts, t.Format(time.RFC3339))
+		} else if val != nil {
 			parts = append(parts, fmt.Sprintf("%v", val))
 		} else {
 			parts = append(parts, "<nil>")
@@ -65,6 +79,23 @@ func BuildGroupKeyString(group map[string]interface{}, groupByFields []string) s
 	return strings.Join(parts, "|")
 }
 
+// groupKeyOf creates a map key from group-by field values. Unlike BuildGroupKeyString
+// every value is quoted, so values containing the separator never merge two groups.
+func groupKeyOf(group map[string]interface{}, groupByFields []string) string {
+	parts := make([]string, 0, len(groupByFields))
+	for _, field := range groupByFields {
+		val := group[field]
+		if t, ok := val.(time.Time); ok {
+			parts = append(parts, strconv.Quote(t.Format(time.RFC3339Nano)))
+		} else if val != nil {
+			parts = append(parts, strconv.Quote(fmt.Sprintf("%v", val)))
+		} else {
+			parts = append(parts, "nil")
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
 // ToFloat64 converts any numeric type to float64.
 func ToFloat64(v interface{}) (float64, bool) {
 	switch n := v.(type) {
@@ -14,36 +14,55 @@
 package cache
 
 import (
+	"errors"
+	"fmt"
+	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/l8types/go/types/l8api"
 )
 
 // filterByHaving filters grouped aggregate results by the HAVING clause.
-// Returns only groups whose aggregate values satisfy the HAVING expression.
-func filterByHaving(groups []map[string]interface{}, having ifs.IExpression) []map[string]interface{} {
+// Returns only groups whose aggregate values satisfy the HAVING expression,
+// or an error if the expression cannot be evaluated on the groups.
+func filterByHaving(groups []map[string]interface{}, having ifs.IExpression, aggregates []*l8api.L8AggregateFunction) ([]map[string]interface{}, error) {
 	if having == nil {
-		return groups
+		return groups, nil
 	}
+	h := &havingContext{aggregates: aggregates}
 	result := make([]map[string]interface{}, 0)
 	for _, group := range groups {
-		if matchHaving(group, having) {
+		ok, err := h.matchHaving(group, having)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
 			result = append(result, group)
 		}
 	}
-	return result
+	return result, nil
+}
+
+// havingContext resolves the operands of a HAVING clause, aggregates are the
+// aggregate functions of the query, so an operand may also be written as the
+// function, e.g. max(latency) for its alias.
+type havingContext struct {
+	aggregates []*l8api.L8AggregateFunction
 }
 
 // matchHaving evaluates a HAVING expression against a group's aggregate results.
 // The expression tree is walked recursively, evaluating conditions against map values.
-func matchHaving(group map[string]interface{}, expr ifs.IExpression) bool {
+func (this *havingContext) matchHaving(group map[string]interface{}, expr ifs.IExpression) (bool, error) {
 	if expr == nil {
-		return true
+		return true, nil
 	}
 
 	condResult := true
 	childResult := true
 	nextResult := true
+	var err error
 
 	isOr := expr.Operator() == "or"
 	if isOr {
@@ -54,81 +73,292 @@ func matchHaving(group map[string]interface{}, expr ifs.IExpression) bool {
 
 	// Evaluate this node's condition
 	if expr.Condition() != nil {
-		condResult = matchHavingCondition(group, expr.Condition())
+		if condResult, err = this.matchHavingCondition(group, expr.Condition()); err != nil {
+			return false, err
+		}
 	}
 
 	// Evaluate child (nested expression)
 	if expr.Child() != nil {
-		childResult = matchHaving(group, expr.Child())
+		if childResult, err = this.matchHaving(group, expr.Child()); err != nil {
+			return false, err
+		}
 	}
 
 	// Evaluate next (chained expression)
 	if expr.Next() != nil {
-		nextResult = matchHaving(group, expr.Next())
+		if nextResult, err = this.matchHaving(group, expr.Next()); err != nil {
+			return false, err
+		}
 	}
 
 	if isOr {
-		return condResult || childResult || nextResult
+		return condResult || childResult || nextResult, nil
 	}
-	return condResult && childResult && nextResult
+	return condResult && childResult && nextResult, nil
 }
 
 // matchHavingCondition evaluates a single HAVING condition chain against a group.
-func matchHavingCondition(group map[string]interface{}, cond ifs.ICondition) bool {
+func (this *havingContext) matchHavingCondition(group map[string]interface{}, cond ifs.ICondition) (bool, error) {
 	if cond == nil {
-		return true
+		return true, nil
 	}
 
-	compResult := matchHavingComparator(group, cond.Comparator())
+	compResult, err := this.matchHavingComparator(group, cond.Comparator())
+	if err != nil {
+		return false, err
+	}
 
 	if cond.Next() == nil {
-		return compResult
+		return compResult, nil
 	}
 
-	nextResult := matchHavingCondition(group, cond.Next())
+	nextResult, err := this.matchHavingCondition(group, cond.Next())
+	if err != nil {
+		return false, err
+	}
 
 	if cond.Operator() == "or" {
-		return compResult || nextResult
+		return compResult || nextResult, nil
 	}
-	return compResult && nextResult
+	return compResult && nextResult, nil
 }
 
 // matchHavingComparator evaluates a single comparison against a group's aggregate map.
-// Left operand is looked up in the group map (aggregate alias), right is a literal value.
-func matchHavingComparator(group map[string]interface{}, comp ifs.IComparator) bool {
+// Left operand is an aggregate alias or a group by property. Right operand is another
+// alias or property, or a literal: a number, true/false, a string optionally quoted and
+// for in and not in a list such as (a,b,c). Strings are matched with * wildcards for =
+// and != and with % and _ wildcards for like and not like.
+func (this *havingContext) matchHavingComparator(group map[string]interface{}, comp ifs.IComparator) (bool, error) {
 	if comp == nil {
-		return true
+		return true, nil
 	}
 
-	leftKey := comp.Left()
-	leftVal, ok := group[leftKey]
+	leftVal, known, ok := this.operand(group, comp.Left())
+	if !known {
+		return false, errors.New("Unknown HAVING operand " + comp.Left())
+	}
 	if !ok {
-		return false
+		// the aggregate has no value for the group, e.g. max of no values
+		return false, nil
+	}
+
+	operator := strings.ToLower(strings.Join(strings.Fields(comp.Operator()), " "))
+	right := strings.TrimSpace(comp.Right())
+
+	switch operator {
+	case "in", "not in":
+		match := false
+		for _, item := range splitHavingList(right) {
+			equal, err := compareHaving(leftVal, "=", item)
+			if err != nil {
+				return false, err
+			}
+			if equal {
+				match = true
+				break
+			}
+		}
+		return match == (operator == "in"), nil
+	case "like", "not like":
+		s, ok := havingString(leftVal)
+		if !ok {
+			return false, errors.New("HAVING " + comp.Left() + " is not a string for " + operator)
+		}
+		match := likePattern(unquote(right)).MatchString(s)
+		return match == (operator == "like"), nil
+	}
+
+	// an unquoted right operand naming an alias or property is compared to its value
+	if !isQuoted(right) {
+		if rightVal, known, ok := this.operand(group, right); known {
+			if !ok {
+				return false, nil
+			}
+			return compareHavingValues(leftVal, operator, rightVal)
+		}
 	}
+	return compareHaving(leftVal, operator, right)
+}
+
+// operand returns the value of the alias or group by property in the group. known is
+// false if the name is neither, ok is false if it is known but has no value.
+func (this *havingContext) operand(group map[string]interface{}, name string) (interface{}, bool, bool) {
+	name = strings.TrimSpace(name)
+	if v, ok := group[name]; ok {
+		return v, true, v != nil
+	}
+	for key, v := range group {
+		if strings.EqualFold(key, name) {
+			return v, true, v != nil
+		}
+	}
+	for _, agg := range this.aggregates {
+		if strings.EqualFold(agg.Alias, name) || strings.EqualFold(agg.Function+"("+agg.Property+")", strings.ReplaceAll(name, " ", "")) {
+			v, ok := group[agg.Alias]
+			return v, true, ok && v != nil
+		}
+	}
+	return nil, false, false
+}
 
-	leftFloat, ok := ToFloat64(leftVal)
+// compareHaving compares a group value to a literal.
+func compareHaving(left interface{}, operator, literal string) (bool, error) {
+	quoted := isQuoted(literal)
+	literal = unquote(literal)
+
+	if b, ok := left.(bool); ok {
+		rb, err := strconv.ParseBool(literal)
+		if err != nil {
+			return false, errors.New("HAVING cannot compare a boolean to " + literal)
+		}
+		return compareEquality(b == rb, operator)
+	}
+
+	if !quoted {
+		if rf, err := strconv.ParseFloat(literal, 64); err == nil {
+			if lf, ok := ToFloat64(left); ok {
+				return compareFloats(lf, operator, rf)
+			}
+		}
+	}
+
+	// strings and enums, by name
+	s, ok := havingString(left)
 	if !ok {
-		return false
+		return false, errors.New("HAVING cannot compare " + fmt.Sprint(left) + " to " + literal)
 	}
+	if (operator == "=" || operator == "!=") && strings.Contains(literal, "*") {
+		return compareEquality(wildcardPattern(literal).MatchString(s), operator)
+	}
+	return compareStrings(s, operator, literal)
+}
 
-	rightFloat, err := strconv.ParseFloat(comp.Right(), 64)
-	if err != nil {
-		return false
+// compareHavingValues compares two group values, e.g. two aggregates.
+func compareHavingValues(left interface{}, operator string, right interface{}) (bool, error) {
+	if lf, ok := ToFloat64(left); ok {
+		if rf, ok := ToFloat64(right); ok {
+			return compareFloats(lf, operator, rf)
+		}
 	}
+	if lb, ok := left.(bool); ok {
+		if rb, ok := right.(bool); ok {
+			return compareEquality(lb == rb, operator)
+		}
+	}
+	ls, lok := havingString(left)
+	rs, rok := havingString(right)
+	if !lok || !rok {
+		return false, errors.New("HAVING cannot compare " + fmt.Sprint(left) + " to " + fmt.Sprint(right))
+	}
+	return compareStrings(ls, operator, rs)
+}
 
-	switch comp.Operator() {
+func compareFloats(left float64, operator string, right float64) (bool, error) {
+	switch operator {
 	case "=":
-		return leftFloat == rightFloat
+		return left == right, nil
 	case "!=":
-		return leftFloat != rightFloat
+		return left != right, nil
 	case ">":
-		return leftFloat > rightFloat
+		return left > right, nil
 	case "<":
-		return leftFloat < rightFloat
+		return left < right, nil
 	case ">=":
-		return leftFloat >= rightFloat
+		return left >= right, nil
 	case "<=":
-		return leftFloat <= rightFloat
+		return left <= right, nil
+	}
+	return false, errors.New("Unsupported HAVING operator " + operator)
+}
+
+func compareStrings(left, operator, right string) (bool, error) {
+	switch operator {
+	case "=":
+		return left == right, nil
+	case "!=":
+		return left != right, nil
+	case ">":
+		return left > right, nil
+	case "<":
+		return left < right, nil
+	case ">=":
+		return left >= right, nil
+	case "<=":
+		return left <= right, nil
+	}
+	return false, errors.New("Unsupported HAVING operator " + operator)
+}
+
+func compareEquality(equal bool, operator string) (bool, error) {
+	switch operator {
+	case "=":
+		return equal, nil
+	case "!=":
+		return !equal, nil
+	}
+	return false, errors.New("Unsupported HAVING operator " + operator + " for booleans")
+}
+
+// havingString returns strings, and enums and other values with a String method, as a string.
+func havingString(v interface{}) (string, bool) {
+	switch s := v.(type) {
+	case string:
+		return s, true
+	case fmt.Stringer:
+		return s.String(), true
+	}
+	return "", false
+}
+
+func isQuoted(s string) bool {
+	return len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0]
+}
+
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if isQuoted(s) {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// splitHavingList splits an IN list, with or without parentheses or brackets.
+func splitHavingList(list string) []string {
+	list = strings.TrimSpace(list)
+	if len(list) >= 2 && (list[0] == '(' && list[len(list)-1] == ')' || list[0] == '[' && list[len(list)-1] == ']') {
+		list = list[1 : len(list)-1]
+	}
+	items := strings.Split(list, ",")
+	for i, item := range items {
+		items[i] = strings.TrimSpace(item)
+	}
+	return items
+}
+
+// likePattern compiles a SQL LIKE pattern, % is any sequence and _ is any character.
+func likePattern(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, c := range pattern {
+		switch c {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// wildcardPattern compiles a pattern where * is any sequence.
+func wildcardPattern(pattern string) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
 	}
-	return false
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
 }
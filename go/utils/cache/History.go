@@ -0,0 +1,119 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+// HistoryEntry is a past revision of a cached element.
+type HistoryEntry struct {
+	Revision uint64
+	Time     time.Time
+	// Value is a copy of the element at this revision, nil if it was deleted.
+	Value   interface{}
+	Deleted bool
+}
+
+// SetHistory keeps the past revisions of every element, up to maxRevisions per element
+// and/or for maxAge, zero meaning no bound. Both zero disables the history. The current
+// elements are recorded as the first revision, so earlier times are not answered.
+// Without maxAge the history of an element is dropped when it is deleted, otherwise
+// it is kept until its deletion is older than maxAge.
+func (this *Cache) SetHistory(maxRevisions int, maxAge time.Duration) error {
+	if !this.cacheEnabled() {
+		return errors.New("History is not kept when the cache is disabled")
+	}
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	if maxRevisions <= 0 && maxAge <= 0 {
+		this.iCache.history = nil
+		return nil
+	}
+	if this.iCache.history != nil {
+		this.iCache.history.maxRevisions = maxRevisions
+		this.iCache.history.maxAge = maxAge
+		this.iCache.history.pruneAll(time.Now().UnixNano())
+		return nil
+	}
+	this.iCache.history = newHistory(maxRevisions, maxAge)
+	this.iCache.forEach(func(pk string, v interface{}) {
+		this.iCache.recordHistory(pk, v, this.iCache.revisionOf(pk))
+	})
+	return nil
+}
+
+// History returns the kept revisions of the element with the key of v, oldest first,
+// including its deletion if it was deleted.
+func (this *Cache) History(v interface{}) ([]*HistoryEntry, error) {
+	pk, err := this.historyKey(v)
+	if err != nil {
+		return nil, err
+	}
+	unlock := this.sharedLock()
+	defer unlock()
+	if this.iCache.history == nil {
+		return nil, errors.New("History is not enabled")
+	}
+	entries := this.iCache.history.revisionsOf(pk)
+	result := make([]*HistoryEntry, len(entries))
+	for i, entry := range entries {
+		result[i] = historyEntryOf(entry)
+	}
+	return result, nil
+}
+
+// GetAsOf returns a copy of the element with the key of v as it was at the given time.
+// Fails if the element did not exist at that time or it is beyond the kept history.
+func (this *Cache) GetAsOf(v interface{}, asOf time.Time) (interface{}, error) {
+	pk, err := this.historyKey(v)
+	if err != nil {
+		return nil, err
+	}
+	unlock := this.sharedLock()
+	defer unlock()
+	if this.iCache.history == nil {
+		return nil, errors.New("History is not enabled")
+	}
+	entry, ok := this.iCache.history.asOf(pk, asOf.UnixNano())
+	if !ok || entry.value == nil {
+		return nil, errors.New("Not found in the cache history as of " + asOf.String())
+	}
+	return cloner.Clone(entry.value), nil
+}
+
+func (this *Cache) historyKey(v interface{}) (string, error) {
+	pk, uk, err := this.KeysFor(v)
+	if err != nil && uk == "" {
+		return "", err
+	}
+	if pk == "" && uk != "" {
+		pk = this.iCache.primaryOf(uk)
+	}
+	if pk == "" {
+		return "", errors.New("Interface does not contain the Key attributes")
+	}
+	return pk, nil
+}
+
+func historyEntryOf(entry historyEntry) *HistoryEntry {
+	result := &HistoryEntry{Revision: entry.revision, Time: time.Unix(0, entry.stamp)}
+	if entry.value == nil {
+		result.Deleted = true
+	} else {
+		result.Value = cloner.Clone(entry.value)
+	}
+	return result
+}
@@ -0,0 +1,51 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"sort"
+)
+
+// AddIndex declares a secondary index on the given property path (e.g. "Status" or
+// "Info.SiteId"). The index is built from the current cache content and maintained
+// by Post, Put, Patch and Delete. Fetch uses it to narrow the elements a query is
+// evaluated on when its WHERE clause has equality, IN or range terms on the property.
+func (this *Cache) AddIndex(propertyPath string) error {
+	property, err := newPropertyPath(this.elemType, propertyPath)
+	if err != nil {
+		return err
+	}
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.iCache.addIndex(property)
+	return nil
+}
+
+// Indexes returns the property paths of the declared secondary indexes.
+func (this *Cache) Indexes() []string {
+	this.mtx.RLock()
+	defer this.mtx.RUnlock()
+	result := make([]string, 0, len(this.iCache.indexes))
+	for _, property := range this.iCache.indexes {
+		result = append(result, property.path)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// IndexedFetches returns how many query preparations were narrowed by a secondary
+// index instead of scanning the whole cache.
+func (this *Cache) IndexedFetches() uint64 {
+	return this.iCache.indexedFetches.Load()
+}
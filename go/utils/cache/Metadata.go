@@ -24,18 +24,18 @@ func (this *Cache) AddMetadataFunc(name string, f func(interface{}) (bool, strin
 }
 
 func (this *Cache) Metadata() map[string]float64 {
-	this.mtx.RLock()
-	defer this.mtx.RUnlock()
+	unlock := this.sharedLock()
+	defer unlock()
 	result := make(map[string]float64)
 	if this.iCache.metadataFunc != nil {
-		for _, elem := range this.iCache.cache {
+		this.iCache.forEach(func(pk string, elem interface{}) {
 			for name, f := range this.iCache.metadataFunc {
 				ok1, _ := f(elem)
 				if ok1 {
 					result[name]++
 				}
 			}
-		}
+		})
 	}
 	return result
 }
@@ -33,8 +33,13 @@ func (this *Cache) Patch(v interface{}, createNotification bool) (*l8notify.L8No
 		return nil, nil, errors.New("Patch Interface does not contain the Key attributes")
 	}
 
-	this.mtx.Lock()
-	defer this.mtx.Unlock()
+	unlock := this.patchLock(pk)
+	defer unlock()
+	return this.coalesced(this.doPatch(pk, uk, v, createNotification))
+}
+
+// doPatch implements Patch with the cache patch lock of pk held.
+func (this *Cache) doPatch(pk, uk string, v interface{}, createNotification bool) (*l8notify.L8NotificationSet, *l8notify.L8NotificationSet, error) {
 	var n *l8notify.L8NotificationSet
 	var e error
 	var item interface{}
@@ -43,24 +48,30 @@ func (this *Cache) Patch(v interface{}, createNotification bool) (*l8notify.L8No
 	if this.cacheEnabled() {
 		item, ok = this.iCache.get(pk, uk)
 	} else {
-		item, e = this.store.Get(pk)
+		item, e = this.readStore(pk)
 		ok = e == nil
 	}
 
 	//If the item does not exist in the cache
 	if !ok {
+		watch := this.watchBefore(nil)
 		//Clone the value for the cache
 		vClone := cloner.Clone(v)
 
 		if this.cacheEnabled() {
 			//Place the new Item clone in the cache
 			this.iCache.put(pk, uk, vClone)
+			this.iCache.expiries.set(pk, this.expiryOf(vClone, 0))
 		}
 
 		if this.store != nil {
 			//place the new item clone in the store
-			e = this.store.Put(pk, vClone)
+			e = this.storePut(pk, vClone)
+		}
+		if e == nil {
+			e = this.walAppend(l8notify.L8NotificationType_Post, pk, vClone)
 		}
+		affected := this.watchAfter(watch, pk, nil, vClone)
 
 		if !createNotification {
 			return n, nil, e
@@ -69,7 +80,7 @@ func (this *Cache) Patch(v interface{}, createNotification bool) (*l8notify.L8No
 		//Clone the value for the notification
 		//itemClone := cloner.Clone(v)
 		n, e = this.createAddNotification(vClone, pk)
-		return n, this.createClientNotification(n), e
+		return n, this.createClientNotification(n, affected), e
 	}
 
 	//Create a new updater
@@ -86,22 +97,37 @@ func (this *Cache) Patch(v interface{}, createNotification bool) (*l8notify.L8No
 		return n, nil, e
 	}
 
+	//Evaluate the watches before the cached item is changed in place
+	watch := this.watchBefore(item)
+
 	//Apply the changes to the existing item in the cache
-	for _, change := range changes {
-		change.Apply(item)
+	if this.cacheEnabled() {
+		item = this.iCache.patch(pk, item, changes, this.copyOnWrite.Load())
+		if this.ttlField != nil {
+			this.iCache.expiries.set(pk, this.expiryOf(item, 0))
+		}
+	} else {
+		for _, change := range changes {
+			change.Apply(item)
+		}
 	}
 
-	this.iCache.stampChanged()
-
 	if this.store != nil {
-		e = this.store.Put(pk, item)
+		e = this.storePut(pk, item)
 	}
+	if e == nil {
+		e = this.walAppend(l8notify.L8NotificationType_Put, pk, item)
+	}
+	affected := this.watchAfter(watch, pk, nil, item)
 
 	if !createNotification {
 		return n, nil, e
 	}
 
 	n, e = this.createUpdateNotification(changes, pk)
-	cn := this.createClientNotificationForPatch(item, pk)
+	if n == nil {
+		return n, nil, e
+	}
+	cn := this.createClientNotificationForPatch(item, pk, affected, n.Sequence)
 	return n, cn, e
 }
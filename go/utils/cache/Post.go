@@ -15,6 +15,7 @@ package cache
 
 import (
 	"errors"
+	"time"
 
 	"github.com/saichler/l8reflect/go/reflect/updating"
 	"github.com/saichler/l8types/go/types/l8notify"
@@ -25,6 +26,11 @@ import (
 // If createNotification is true, generates an Add or Replace notification for distributed sync.
 // Returns the delta notification, client notification (if subscribers exist), and any error.
 func (this *Cache) Post(v interface{}, createNotification bool) (*l8notify.L8NotificationSet, *l8notify.L8NotificationSet, error) {
+	return this.post(v, createNotification, 0)
+}
+
+// post implements Post and Put, ttl overrides the default element TTL when set.
+func (this *Cache) post(v interface{}, createNotification bool, ttl time.Duration) (*l8notify.L8NotificationSet, *l8notify.L8NotificationSet, error) {
 	pk, uk, err := this.KeysFor(v)
 	if err != nil {
 		return nil, nil, err
@@ -36,20 +42,31 @@ func (this *Cache) Post(v interface{}, createNotification bool) (*l8notify.L8Not
 	//Make sure we clone the input value, so the caller don't have a reference to the cache element
 	v = cloner.Clone(v)
 
+	unlock := this.writeLock(pk)
+	defer unlock()
+	return this.coalesced(this.doPost(pk, uk, v, createNotification, ttl))
+}
+
+// doPost implements post with the cache write lock of pk held, v is already a clone of
+// the input.
+func (this *Cache) doPost(pk, uk string, v interface{}, createNotification bool, ttl time.Duration) (*l8notify.L8NotificationSet, *l8notify.L8NotificationSet, error) {
 	var n *l8notify.L8NotificationSet
 	var e error
 	var item interface{}
 	var ok bool
 
-	this.mtx.Lock()
-	defer this.mtx.Unlock()
-
 	if this.cacheEnabled() {
 		item, ok = this.iCache.get(pk, uk)
 	} else {
-		item, e = this.store.Get(pk)
+		item, e = this.readStore(pk)
 		ok = e == nil
 	}
+	var watch *watchState
+	if ok {
+		watch = this.watchBefore(item)
+	} else {
+		watch = this.watchBefore(nil)
+	}
 
 	//If the item does not exist in the cache
 	if !ok {
@@ -58,17 +75,23 @@ func (this *Cache) Post(v interface{}, createNotification bool) (*l8notify.L8Not
 		if this.cacheEnabled() {
 			//Place the value in the cache
 			this.iCache.put(pk, uk, v)
+			this.iCache.expiries.set(pk, this.expiryOf(v, ttl))
 		}
 		if this.store != nil {
-			e = this.store.Put(pk, v)
+			e = this.storePut(pk, v)
 			if e != nil {
 				return n, nil, e
 			}
 		}
+		e = this.walAppend(l8notify.L8NotificationType_Post, pk, v)
+		if e != nil {
+			return n, nil, e
+		}
+		affected := this.watchAfter(watch, pk, nil, v)
 		//Create the notification using the clone outside the current go routine
 		if createNotification {
 			n, e = this.createAddNotification(itemClone, pk)
-			return n, this.createClientNotification(n), e
+			return n, this.createClientNotification(n, affected), e
 		}
 		return n, nil, e
 	}
@@ -77,40 +100,42 @@ func (this *Cache) Post(v interface{}, createNotification bool) (*l8notify.L8Not
 	//Clone the instance so it won't be able to be updated outside the scope
 	vClone := cloner.Clone(v)
 
+	//Compare with the existing item, without updating it as readers may still hold it
+	putUpdater := updating.NewUpdater(this.r, true, true)
+	e = putUpdater.DryUpdate(item, vClone)
+	if e != nil {
+		return n, nil, e
+	}
+	changes := putUpdater.Changes()
+
 	if this.cacheEnabled() {
 		//Place the value in the cache
 		this.iCache.put(pk, uk, vClone)
+		this.iCache.expiries.set(pk, this.expiryOf(vClone, ttl))
 	}
 
 	if this.store != nil {
-		e = this.store.Put(pk, vClone)
+		e = this.storePut(pk, vClone)
 		if e != nil {
 			return n, nil, e
 		}
 	}
 
-	if !createNotification {
-		return n, nil, e
+	//if there are no changes, there is nothing to log, watch or notify
+	if len(changes) == 0 {
+		return nil, nil, nil
 	}
 
-	//From this point onward, the item is no longer in the cache
-	//so we don't need to clone it
-
-	//Create a new updater
-	putUpdater := updating.NewUpdater(this.r, true, true)
-
-	//update the item clone with the new element where nil is valid
-	e = putUpdater.Update(item, vClone)
+	e = this.walAppend(l8notify.L8NotificationType_Put, pk, vClone)
 	if e != nil {
 		return n, nil, e
 	}
+	affected := this.watchAfter(watch, pk, item, vClone)
 
-	//if there are changes, then nothing to do
-	changes := putUpdater.Changes()
-	if changes == nil || len(changes) == 0 {
-		return nil, nil, nil
+	if !createNotification {
+		return n, nil, e
 	}
 
 	n, e = this.createReplaceNotification(item, v, pk)
-	return n, this.createClientNotification(n), e
+	return n, this.createClientNotification(n, affected), e
 }
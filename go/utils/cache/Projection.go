@@ -0,0 +1,141 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/saichler/l8types/go/ifs"
+)
+
+// ProjectionMode is how Fetch and FetchCursor return the elements of queries that
+// select properties.
+type ProjectionMode int
+
+const (
+	// ProjectNone returns the full elements regardless of the SELECT list. This is the
+	// default, for compatibility.
+	ProjectNone ProjectionMode = iota
+	// ProjectObjects returns the elements trimmed to the selected properties, as the
+	// query's Filter with only the selected columns returns them.
+	ProjectObjects
+	// ProjectRows returns *ProjectedRow items holding the values of the selected
+	// properties, or of all the top level properties for select *.
+	ProjectRows
+)
+
+// ProjectedRow is an element of a query result in table view.
+type ProjectedRow struct {
+	// Key is the primary key of the element.
+	Key string
+	// Values holds the values of the selected properties, by property name without the
+	// model type, e.g. "mystring" or "address.city".
+	Values map[string]interface{}
+}
+
+// projectionQuery is implemented by queries that expose their SELECT list.
+type projectionQuery interface {
+	Properties() []ifs.IProperty
+}
+
+// SetProjectionMode sets how Fetch and FetchCursor return the elements of queries
+// that select properties.
+func (this *Cache) SetProjectionMode(mode ProjectionMode) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.projectionMode = mode
+}
+
+// project returns the page of elements of the query to give to the caller, in the
+// projection mode.
+func (this *Cache) project(q ifs.IQuery, keys []string, values []interface{}) []interface{} {
+	switch this.projectionMode {
+	case ProjectObjects:
+		// Filter may trim the elements it is given in place, so it is given copies and
+		// never the cached elements
+		copies := make([]interface{}, len(values))
+		for i, v := range values {
+			copies[i] = cloner.Clone(v)
+		}
+		return q.Filter(copies, true)
+	case ProjectRows:
+		if columns := this.iCache.projectedColumns(q); columns != nil {
+			result := make([]interface{}, len(values))
+			for i, v := range values {
+				row := &ProjectedRow{Key: keys[i], Values: make(map[string]interface{}, len(columns))}
+				for _, column := range columns {
+					if value, ok := column.valueOf(v); ok {
+						row.Values[column.path] = this.readValue(value)
+					}
+				}
+				result[i] = row
+			}
+			return result
+		}
+	}
+	result := make([]interface{}, len(values))
+	for i, v := range values {
+		result[i] = this.readCopy(v)
+	}
+	return result
+}
+
+// readValue returns a property value to give to a reader, copying values that may
+// be shared with the cached element.
+func (this *Cache) readValue(v interface{}) interface{} {
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Struct:
+		return this.readCopy(v)
+	}
+	return v
+}
+
+// projectedColumns resolves the selected properties of the query, or all the top level
+// properties of the model when it selects all of them. Returns nil if the query does
+// not expose its SELECT list or a property cannot be resolved.
+func (this *internalCache) projectedColumns(q ifs.IQuery) []*propertyPath {
+	pq, ok := q.(projectionQuery)
+	if !ok {
+		return nil
+	}
+	names := make([]string, 0)
+	for _, property := range pq.Properties() {
+		id, err := property.PropertyId()
+		if err != nil {
+			return nil
+		}
+		names = append(names, normalizePropertyName(id, this.modelType))
+	}
+	if len(names) == 0 {
+		t := this.elemType
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).IsExported() {
+				names = append(names, strings.ToLower(t.Field(i).Name))
+			}
+		}
+	}
+	columns := make([]*propertyPath, 0, len(names))
+	for _, name := range names {
+		column, err := newPropertyPath(this.elemType, name)
+		if err != nil {
+			return nil
+		}
+		columns = append(columns, column)
+	}
+	return columns
+}
@@ -0,0 +1,192 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/l8types/go/types/l8api"
+)
+
+// QueryStorage is implemented by stores that execute queries themselves. When the store
+// has the cache disabled, Fetch pushes the WHERE, ORDER BY and LIMIT of queries down to
+// it, and aggregate queries their WHERE. Stores without it are scanned with Collect.
+type QueryStorage interface {
+	// SupportsQuery returns true if the store can execute the criteria and the sort
+	// of the query, otherwise the query is evaluated on a scan of the store.
+	SupportsQuery(q ifs.IQuery) bool
+	// Query returns the elements matching the criteria of the query, sorted by its sort
+	// property, from start and up to limit elements (0 for all), and the number of the
+	// elements matching the criteria.
+	Query(q ifs.IQuery, start, limit int) ([]interface{}, int, error)
+}
+
+// queryStorage returns the store if it can execute the query, with the cache disabled.
+func (this *Cache) queryStorage(q ifs.IQuery) (QueryStorage, bool) {
+	if this.store == nil || this.store.CacheEnabled() {
+		return nil, false
+	}
+	qs, ok := this.store.(QueryStorage)
+	if !ok || !qs.SupportsQuery(q) {
+		return nil, false
+	}
+	return qs, true
+}
+
+// queryCache returns the internal cache to evaluate the query on, with the cache lock
+// held. With the cache disabled it is a transient cache of the store elements matching
+// the query's criteria, or of all of them if the store cannot execute the query. A scan
+// costs a copy of the elements it holds, it is made on the first page of a fetch and
+// reused by its following pages, see storeScans, so first is true for a first page.
+func (this *Cache) queryCache(q ifs.IQuery, first bool) (*internalCache, error) {
+	if this.cacheEnabled() {
+		return this.iCache, nil
+	}
+	key := preparedKey(q)
+	writes := this.storeWrites.Load()
+	if !first {
+		if view, ok := this.scans.get(key, writes); ok {
+			return view, nil
+		}
+	}
+	view, err := this.scanStore(q)
+	if err != nil {
+		return nil, err
+	}
+	this.scans.put(key, view, writes)
+	return view, nil
+}
+
+// scanStore returns a transient cache of the store elements the query is evaluated on.
+func (this *Cache) scanStore(q ifs.IQuery) (*internalCache, error) {
+	view := newInternalCache(this.modelType, this.elemType)
+	view.metadataFunc = this.iCache.metadataFunc
+	view.histogramBounds = this.iCache.histogramBounds
+	view.timeBuckets = this.iCache.timeBuckets
+
+	var elements []interface{}
+	if qs, ok := this.queryStorage(q); ok {
+		var err error
+		elements, _, err = this.queryStore(qs, q, 0, 0)
+		if err != nil {
+			return nil, errors.New("Failed to query the store of " + this.modelType + ": " + err.Error())
+		}
+	} else {
+		for _, v := range this.collectStore(allElementsInCache) {
+			elements = append(elements, v)
+		}
+	}
+	for _, v := range elements {
+		pk, uk, err := this.KeysFor(v)
+		if err != nil {
+			this.logQueryError(err)
+			continue
+		}
+		view.put(pk, uk, v)
+	}
+	return view, nil
+}
+
+// storeScan is the transient cache of a query evaluated on a scan of the store, writes
+// is the number of the store writes of the cache when it was scanned.
+type storeScan struct {
+	view     *internalCache
+	writes   uint64
+	lastUsed int64
+}
+
+// storeScans keeps the scans of the queries evaluated on a scan of the store, with the
+// cache disabled, so the following pages of a fetch reuse the scan of its first page
+// instead of scanning the store again. A scan is dropped when the cache writes to the
+// store, and by the TTL cleaner once unused for the query TTL. Each kept scan holds a
+// copy of the elements it scanned.
+type storeScans struct {
+	mtx   *sync.Mutex
+	scans map[int64]*storeScan
+}
+
+func newStoreScans() *storeScans {
+	return &storeScans{mtx: &sync.Mutex{}, scans: make(map[int64]*storeScan)}
+}
+
+// get returns the scan of the query key, false if there is none or the cache wrote to
+// the store since it was scanned.
+func (this *storeScans) get(key int64, writes uint64) (*internalCache, bool) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	scan, ok := this.scans[key]
+	if !ok || scan.writes != writes {
+		return nil, false
+	}
+	scan.lastUsed = time.Now().Unix()
+	return scan.view, true
+}
+
+func (this *storeScans) put(key int64, view *internalCache, writes uint64) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.scans[key] = &storeScan{view: view, writes: writes, lastUsed: time.Now().Unix()}
+}
+
+// cleanup drops the scans unused for ttlSeconds, returning how many were dropped.
+func (this *storeScans) cleanup(ttlSeconds int64) int {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	now := time.Now().Unix()
+	removed := 0
+	for key, scan := range this.scans {
+		if now-scan.lastUsed > ttlSeconds {
+			delete(this.scans, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// fetchFromStore fetches a page of a query that is not an aggregate from the store, with
+// the cache disabled. It returns false if the store cannot execute the query, and the
+// error of the store if it failed to. The metadata of the page only holds the Total count.
+func (this *Cache) fetchFromStore(start, blockSize int, q ifs.IQuery) ([]string, []interface{}, *l8api.L8MetaData, bool, error) {
+	qs, ok := this.queryStorage(q)
+	if !ok || q.IsAggregate() {
+		return nil, nil, nil, false, nil
+	}
+	metadata := newMetadata()
+	elements, total, err := this.queryStore(qs, q, start, blockSize)
+	if err != nil {
+		return nil, nil, nil, true, errors.New("Failed to query the store of " + this.modelType + ": " + err.Error())
+	}
+	keys := make([]string, 0, len(elements))
+	values := make([]interface{}, 0, len(elements))
+	for _, v := range elements {
+		pk, _, err := this.KeysFor(v)
+		if err != nil {
+			this.logQueryError(err)
+			continue
+		}
+		keys = append(keys, pk)
+		values = append(values, v)
+	}
+	metadata.KeyCount.Counts[Total] = float64(total)
+	return keys, values, metadata, true, nil
+}
+
+func (this *Cache) logQueryError(err error) {
+	if this.r != nil {
+		this.r.Logger().Error("Failed to query the store of ", this.modelType, ": ", err.Error())
+	}
+}
@@ -0,0 +1,319 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/saichler/l8reflect/go/reflect/properties"
+	"github.com/saichler/l8srlz/go/serialize/object"
+	"github.com/saichler/l8types/go/types/l8notify"
+	"github.com/saichler/l8utils/go/utils/notify"
+)
+
+// Replica applies the notification sets of leader caches, as received over the VNic,
+// to a follower cache so it holds the same elements. The sets of each Source are applied
+// in sequence order, sets that were already applied are skipped, and a gap in the
+// sequence stops applying the Source and asks for a snapshot of the leader, see
+// Cache.Snapshot, to be given to ApplySnapshot. Mutations done without a notification
+// on the leader are not replicated. The replica keeps the Source of every replicated
+// element, so a snapshot replaces only the elements of its Source.
+type Replica struct {
+	follower *Cache
+	resync   func(source string, sequence uint32)
+	sources  map[string]*replicaSource
+	owners   map[string]string
+	mtx      *sync.Mutex
+}
+
+// replicaSource is the replication state of a leader, next is the sequence of its next
+// notification set, pending are the sets received while waiting for a snapshot.
+type replicaSource struct {
+	next      uint32
+	resyncing bool
+	pending   []*l8notify.L8NotificationSet
+}
+
+// NewReplica creates a replica applying to the follower cache. resync is called, outside
+// of the replica's lock, with the Source and the missing sequence when a gap is detected;
+// it is expected to request a snapshot of the Source, e.g. with a VNic request, and give
+// it to ApplySnapshot. The stream of a Source is expected to start at sequence 0, unless
+// a snapshot of it was applied first.
+func NewReplica(follower *Cache, resync func(source string, sequence uint32)) *Replica {
+	return &Replica{follower: follower, resync: resync, sources: make(map[string]*replicaSource),
+		owners: make(map[string]string), mtx: &sync.Mutex{}}
+}
+
+// Apply applies a notification set of a leader to the follower. Sets received while
+// the Source is resyncing are kept and applied after its snapshot.
+func (this *Replica) Apply(n *l8notify.L8NotificationSet) error {
+	if n == nil {
+		return nil
+	}
+	this.mtx.Lock()
+	src := this.sourceOf(n.Source)
+	if src.resyncing {
+		src.pending = append(src.pending, n)
+		this.mtx.Unlock()
+		return nil
+	}
+	gap, err := this.apply(n.Source, src, n)
+	next := src.next
+	this.mtx.Unlock()
+
+	if gap {
+		this.requestResync(n.Source, next)
+	}
+	return err
+}
+
+// ApplySnapshot replaces the elements of the follower replicated from the Source with the
+// elements of a snapshot of the Source's cache, then applies the sets received since the
+// gap that are newer than the snapshot. Elements of other Sources are kept.
+func (this *Replica) ApplySnapshot(source string, reader io.Reader) error {
+	if this.follower.r == nil || this.follower.r.Registry() == nil {
+		return errors.New("Cannot apply a snapshot without a registry")
+	}
+	elements := make([]replicaElement, 0)
+	sequence, _, err := readSnapshot(reader, this.follower.modelType, this.follower.r, func(pk, uk string, expiry int64, revision uint64, v interface{}) {
+		elements = append(elements, replicaElement{pk: pk, uk: uk, expiry: expiry, v: v})
+	})
+	if err != nil {
+		return err
+	}
+
+	this.mtx.Lock()
+	this.follower.replaceReplicated(elements, this.staleOf(source, elements))
+	for _, e := range elements {
+		this.owners[e.pk] = source
+	}
+	src := this.sourceOf(source)
+	src.next = sequence
+	src.resyncing = false
+	pending := src.pending
+	src.pending = nil
+
+	gap := false
+	for _, n := range pending {
+		if src.resyncing {
+			src.pending = append(src.pending, n)
+			continue
+		}
+		var e error
+		gap, e = this.apply(source, src, n)
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+	next := src.next
+	this.mtx.Unlock()
+
+	if gap {
+		this.requestResync(source, next)
+	}
+	return err
+}
+
+// Resyncing returns true if the replica waits for a snapshot of the Source.
+func (this *Replica) Resyncing(source string) bool {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	src, ok := this.sources[source]
+	return ok && src.resyncing
+}
+
+// Sequence returns the sequence of the next notification set expected from the Source.
+func (this *Replica) Sequence(source string) uint32 {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	if src, ok := this.sources[source]; ok {
+		return src.next
+	}
+	return 0
+}
+
+// staleOf returns the keys of the elements replicated from the Source that are not in
+// its snapshot elements.
+func (this *Replica) staleOf(source string, elements []replicaElement) []string {
+	keep := make(map[string]bool, len(elements))
+	for _, e := range elements {
+		keep[e.pk] = true
+	}
+	stale := make([]string, 0)
+	for pk, owner := range this.owners {
+		if owner == source && !keep[pk] {
+			stale = append(stale, pk)
+		}
+	}
+	return stale
+}
+
+func (this *Replica) sourceOf(source string) *replicaSource {
+	src, ok := this.sources[source]
+	if !ok {
+		src = &replicaSource{}
+		this.sources[source] = src
+	}
+	return src
+}
+
+// apply applies the set in sequence. It returns true when a gap was detected, in which
+// case the set is kept until the snapshot.
+func (this *Replica) apply(source string, src *replicaSource, n *l8notify.L8NotificationSet) (bool, error) {
+	if n.Sequence < src.next {
+		// applied already
+		return false, nil
+	}
+	if n.Sequence > src.next {
+		src.resyncing = true
+		src.pending = append(src.pending, n)
+		return true, nil
+	}
+	pk, ok, err := this.follower.applyReplicated(n)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		// the follower does not have the patched element
+		src.resyncing = true
+		src.pending = append(src.pending, n)
+		return true, nil
+	}
+	if n.Type == l8notify.L8NotificationType_Delete {
+		delete(this.owners, pk)
+	} else {
+		this.owners[pk] = source
+	}
+	src.next = n.Sequence + 1
+	return false, nil
+}
+
+func (this *Replica) requestResync(source string, sequence uint32) {
+	if this.follower.r != nil {
+		this.follower.r.Logger().Warning("Replica of ", this.follower.modelType, " missed sequence ", sequence, " of ", source, ", resyncing")
+	}
+	if this.resync != nil {
+		this.resync(source, sequence)
+	}
+}
+
+type replicaElement struct {
+	pk     string
+	uk     string
+	expiry int64
+	v      interface{}
+}
+
+// applyReplicated applies a notification set of a leader without creating notifications.
+// Applying the same set twice has the same result as applying it once. It returns false if
+// the set patches an element the cache does not have, and the key of the element.
+func (this *Cache) applyReplicated(n *l8notify.L8NotificationSet) (string, bool, error) {
+	switch n.Type {
+	case l8notify.L8NotificationType_Post, l8notify.L8NotificationType_Put:
+		item, _, err := notify.ItemOf(n, this.r, false)
+		if err != nil {
+			return "", false, err
+		}
+		pk, uk, err := this.KeysFor(item)
+		if err != nil {
+			return "", false, err
+		}
+		this.mtx.Lock()
+		defer this.mtx.Unlock()
+		_, _, err = this.doPost(pk, uk, item, false, 0)
+		return pk, true, err
+	case l8notify.L8NotificationType_Delete:
+		this.mtx.Lock()
+		defer this.mtx.Unlock()
+		if _, ok := this.replicatedItem(n.ModelKey); !ok {
+			return n.ModelKey, true, nil
+		}
+		_, _, err := this.doDelete(n.ModelKey, "", false)
+		return n.ModelKey, true, err
+	case l8notify.L8NotificationType_Patch:
+		this.mtx.Lock()
+		defer this.mtx.Unlock()
+		item, ok := this.replicatedItem(n.ModelKey)
+		if !ok {
+			return n.ModelKey, false, nil
+		}
+		// set the changed properties on a copy, so a property set to its zero value
+		// is replicated as well
+		item = cloner.Clone(item)
+		for _, notif := range n.NotificationList {
+			var value interface{}
+			if notif.NewValue != nil {
+				v, err := object.NewDecode(notif.NewValue, 0, this.r.Registry()).Get()
+				if err != nil {
+					return "", false, err
+				}
+				value = v
+			}
+			p, err := properties.PropertyOf(notif.PropertyId, this.r)
+			if err != nil {
+				return "", false, err
+			}
+			if _, _, err = p.Set(item, value); err != nil {
+				return "", false, err
+			}
+		}
+		pk, uk, err := this.KeysFor(item)
+		if err != nil {
+			return "", false, err
+		}
+		_, _, err = this.doPost(pk, uk, item, false, 0)
+		return pk, true, err
+	}
+	return "", false, errors.New("Unknown notification type " + n.Type.String())
+}
+
+// replicatedItem returns the element of the primary key, with the cache lock held.
+func (this *Cache) replicatedItem(pk string) (interface{}, bool) {
+	if this.cacheEnabled() {
+		return this.iCache.value(pk)
+	}
+	if this.store == nil {
+		return nil, false
+	}
+	item, err := this.readStore(pk)
+	return item, err == nil && item != nil
+}
+
+// replaceReplicated puts the elements of a snapshot and deletes the stale elements of
+// its Source.
+func (this *Cache) replaceReplicated(elements []replicaElement, stale []string) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	for _, pk := range stale {
+		if _, ok := this.replicatedItem(pk); !ok {
+			continue
+		}
+		if _, _, err := this.doDelete(pk, "", false); err != nil && this.r != nil {
+			this.r.Logger().Error("Failed to delete replicated element ", pk, ": ", err.Error())
+		}
+	}
+	for _, e := range elements {
+		if _, _, err := this.doPost(e.pk, e.uk, e.v, false, 0); err != nil {
+			if this.r != nil {
+				this.r.Logger().Error("Failed to put replicated element ", e.pk, ": ", err.Error())
+			}
+			continue
+		}
+		if e.expiry != 0 && this.cacheEnabled() {
+			this.iCache.expiries.set(e.pk, e.expiry)
+		}
+	}
+}
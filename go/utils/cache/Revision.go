@@ -0,0 +1,132 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/saichler/l8types/go/types/l8notify"
+)
+
+// ConflictError is returned by PutIf, PatchIf and DeleteIf when the revision of the
+// element is not the expected one, i.e. it was changed since it was read.
+type ConflictError struct {
+	Key      string
+	Expected uint64
+	Actual   uint64
+}
+
+func (this *ConflictError) Error() string {
+	return "Revision conflict on " + this.Key + ", expected revision " +
+		strconv.FormatUint(this.Expected, 10) + " but it is " + strconv.FormatUint(this.Actual, 10)
+}
+
+// GetWithRevision retrieves an item like Get, together with its revision. Every Post, Put,
+// Patch and Delete takes the next revision of a counter shared by all the elements, so the
+// revision of an element increases with every change and a deleted and re-added element
+// never gets back a revision it had before.
+func (this *Cache) GetWithRevision(v interface{}) (interface{}, uint64, error) {
+	pk, uk, e := this.KeysFor(v)
+	if e != nil && uk == "" {
+		return nil, 0, e
+	}
+	if pk == "" && uk == "" {
+		return nil, 0, errors.New("Interface does not contain the Key attributes")
+	}
+	if !this.cacheEnabled() {
+		return nil, 0, errors.New("Revisions are not maintained when the cache is disabled")
+	}
+
+	unlock := this.readLock()
+	defer unlock()
+
+	if pk == "" {
+		pk = this.iCache.primaryOf(uk)
+	}
+	item, ok := this.iCache.get(pk, uk)
+	if !ok {
+		return nil, 0, errors.New("Not found in the cache")
+	}
+	return this.readCopy(item), this.iCache.revisionOf(pk), nil
+}
+
+// PutIf replaces the element like Put, only if its revision is the given one. A revision
+// of 0 adds the element only if it does not exist. Fails with a *ConflictError otherwise.
+func (this *Cache) PutIf(v interface{}, revision uint64, createNotification bool) (*l8notify.L8NotificationSet, *l8notify.L8NotificationSet, error) {
+	pk, uk, err := this.conditionalKeys(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	v = cloner.Clone(v)
+	unlock := this.writeLock(pk)
+	defer unlock()
+	if err = this.checkRevision(pk, revision); err != nil {
+		return nil, nil, err
+	}
+	return this.coalesced(this.doPost(pk, uk, v, createNotification, 0))
+}
+
+// PatchIf patches the element like Patch, only if its revision is the given one.
+// Fails with a *ConflictError otherwise.
+func (this *Cache) PatchIf(v interface{}, revision uint64, createNotification bool) (*l8notify.L8NotificationSet, *l8notify.L8NotificationSet, error) {
+	pk, uk, err := this.conditionalKeys(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	unlock := this.patchLock(pk)
+	defer unlock()
+	if err = this.checkRevision(pk, revision); err != nil {
+		return nil, nil, err
+	}
+	return this.coalesced(this.doPatch(pk, uk, v, createNotification))
+}
+
+// DeleteIf deletes the element like Delete, only if its revision is the given one.
+// Fails with a *ConflictError otherwise.
+func (this *Cache) DeleteIf(v interface{}, revision uint64, createNotification bool) (*l8notify.L8NotificationSet, *l8notify.L8NotificationSet, error) {
+	pk, uk, err := this.conditionalKeys(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	unlock := this.writeLock(pk)
+	defer unlock()
+	if err = this.checkRevision(pk, revision); err != nil {
+		return nil, nil, err
+	}
+	return this.coalesced(this.doDelete(pk, uk, createNotification))
+}
+
+func (this *Cache) conditionalKeys(v interface{}) (string, string, error) {
+	pk, uk, err := this.KeysFor(v)
+	if err != nil {
+		return "", "", err
+	}
+	if pk == "" {
+		return "", "", errors.New("Interface does not contain the Key attributes")
+	}
+	if !this.cacheEnabled() {
+		return "", "", errors.New("Revisions are not maintained when the cache is disabled")
+	}
+	return pk, uk, nil
+}
+
+// checkRevision must be called with the cache write lock of pk held.
+func (this *Cache) checkRevision(pk string, revision uint64) error {
+	actual := this.iCache.revisionOf(pk)
+	if actual != revision {
+		return &ConflictError{Key: pk, Expected: revision, Actual: actual}
+	}
+	return nil
+}
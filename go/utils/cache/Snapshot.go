@@ -0,0 +1,223 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/saichler/l8srlz/go/serialize/object"
+	"github.com/saichler/l8types/go/ifs"
+)
+
+// snapshotMagic identifies a cache snapshot stream, followed by the format version.
+const (
+	snapshotMagic   = "L8CS"
+	snapshotVersion = uint16(2)
+	// maxSnapshotField guards against allocating a corrupted field length
+	maxSnapshotField = 1 << 30
+)
+
+// Snapshot writes all the elements of the cache, with their unique keys, expiry and
+// revision, the notification sequence and the revision counter to the writer. The cache
+// is read locked while the snapshot is written so it is a consistent point in time.
+// Evicted elements are reloaded from the store, the snapshot fails if one of them
+// cannot be loaded.
+//
+// Format: magic, version, model type, notification sequence, revision counter, element
+// count, then per element its primary key, unique key, expiry (unix nano, 0 for none),
+// revision and the element encoded with the l8srlz object encoder.
+func (this *Cache) Snapshot(writer io.Writer) error {
+	unlock := this.sharedLock()
+	defer unlock()
+
+	w := bufio.NewWriter(writer)
+	sw := &snapshotWriter{w: w}
+	sw.writeString(snapshotMagic)
+	sw.writeUint(uint64(snapshotVersion), 2)
+	sw.writeString(this.modelType)
+	sw.writeUint(uint64(this.notifySequence.Load()), 4)
+	sw.writeUint(this.iCache.revision.Load(), 8)
+	sw.writeUint(uint64(this.iCache.size()), 4)
+
+	write := func(pk string, v interface{}) {
+		if sw.err != nil {
+			return
+		}
+		obj := object.NewEncode()
+		err := obj.Add(v)
+		if err != nil {
+			sw.err = errors.New("Failed to encode element " + pk + ": " + err.Error())
+			return
+		}
+		sw.writeString(pk)
+		expiry, _ := this.iCache.expiries.get(pk)
+		sw.writeString(this.iCache.uniqueOf(pk))
+		sw.writeUint(uint64(expiry), 8)
+		sw.writeUint(this.iCache.revisionOf(pk), 8)
+		sw.writeBytes(obj.Data())
+	}
+	this.iCache.forEachResident(write)
+	// the header counts the evicted elements, so one that cannot be loaded fails the
+	// snapshot instead of leaving it short
+	for pk := range this.iCache.evicted {
+		if sw.err != nil {
+			break
+		}
+		v, ok := this.iCache.load(pk)
+		if !ok {
+			return errors.New("Failed to load evicted element " + pk + " for the snapshot")
+		}
+		write(pk, v)
+	}
+	if sw.err != nil {
+		return sw.err
+	}
+	return w.Flush()
+}
+
+// RestoreCache creates a cache from a snapshot written by Snapshot instead of loading
+// the elements from the store, so a node can warm-start from a local file and catch up
+// from the restored notification sequence. The elements keep their revisions. The
+// store, if any, is only used for the following mutations.
+func RestoreCache(reader io.Reader, sampleElement interface{}, store ifs.IStorage, r ifs.IResources) (*Cache, error) {
+	if r == nil || r.Registry() == nil {
+		return nil, errors.New("Cannot restore a cache without a registry")
+	}
+	this := newCache(sampleElement, store, r)
+	sequence, revision, err := readSnapshot(reader, this.modelType, r, func(pk, uk string, expiry int64, rev uint64, v interface{}) {
+		this.iCache.setElement(pk, uk, v)
+		this.iCache.setRevision(pk, rev)
+		this.iCache.expiries.set(pk, expiry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	this.notifySequence.Store(sequence)
+	this.iCache.revision.Store(revision)
+
+	this.start()
+	return this, nil
+}
+
+// readSnapshot reads a snapshot of the model type written by Snapshot, calling element
+// for each of its elements, and returns the notification sequence and the revision
+// counter of the snapshot. Snapshots of version 1 have no revisions, their elements
+// get revision 0.
+func readSnapshot(reader io.Reader, modelType string, r ifs.IResources, element func(pk, uk string, expiry int64, revision uint64, v interface{})) (uint32, uint64, error) {
+	sr := &snapshotReader{r: bufio.NewReader(reader)}
+
+	if magic := sr.readString(); sr.err == nil && magic != snapshotMagic {
+		return 0, 0, errors.New("Not a cache snapshot")
+	}
+	version := uint16(sr.readUint(2))
+	if sr.err == nil && (version == 0 || version > snapshotVersion) {
+		return 0, 0, errors.New("Unsupported cache snapshot version " + strconv.Itoa(int(version)))
+	}
+	if snapshotType := sr.readString(); sr.err == nil && snapshotType != modelType {
+		return 0, 0, errors.New("Snapshot is of model type " + snapshotType + ", expected " + modelType)
+	}
+	sequence := uint32(sr.readUint(4))
+	revision := uint64(0)
+	if version > 1 {
+		revision = sr.readUint(8)
+	}
+	count := int(sr.readUint(4))
+	if sr.err != nil {
+		return 0, 0, errors.New("Failed to read snapshot header: " + sr.err.Error())
+	}
+
+	for i := 0; i < count; i++ {
+		pk := sr.readString()
+		uk := sr.readString()
+		expiry := int64(sr.readUint(8))
+		rev := uint64(0)
+		if version > 1 {
+			rev = sr.readUint(8)
+		}
+		data := sr.readBytes()
+		if sr.err != nil {
+			return 0, 0, errors.New("Failed to read snapshot element " + strconv.Itoa(i) + ": " + sr.err.Error())
+		}
+		v, err := object.NewDecode(data, 0, r.Registry()).Get()
+		if err != nil {
+			return 0, 0, errors.New("Failed to decode snapshot element " + pk + ": " + err.Error())
+		}
+		element(pk, uk, expiry, rev, v)
+	}
+	return sequence, revision, nil
+}
+
+// snapshotWriter writes length prefixed, big endian fields, keeping the first error.
+type snapshotWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (this *snapshotWriter) writeUint(v uint64, size int) {
+	if this.err != nil {
+		return
+	}
+	buff := make([]byte, 8)
+	binary.BigEndian.PutUint64(buff, v)
+	_, this.err = this.w.Write(buff[8-size:])
+}
+
+func (this *snapshotWriter) writeBytes(data []byte) {
+	this.writeUint(uint64(len(data)), 4)
+	if this.err != nil {
+		return
+	}
+	_, this.err = this.w.Write(data)
+}
+
+func (this *snapshotWriter) writeString(s string) {
+	this.writeBytes([]byte(s))
+}
+
+// snapshotReader reads the fields written by snapshotWriter, keeping the first error.
+type snapshotReader struct {
+	r   io.Reader
+	err error
+}
+
+func (this *snapshotReader) readUint(size int) uint64 {
+	if this.err != nil {
+		return 0
+	}
+	buff := make([]byte, 8)
+	_, this.err = io.ReadFull(this.r, buff[8-size:])
+	return binary.BigEndian.Uint64(buff)
+}
+
+func (this *snapshotReader) readBytes() []byte {
+	size := this.readUint(4)
+	if this.err != nil {
+		return nil
+	}
+	if size > maxSnapshotField {
+		this.err = errors.New("Field size " + strconv.FormatUint(size, 10) + " exceeds the snapshot limit")
+		return nil
+	}
+	data := make([]byte, size)
+	_, this.err = io.ReadFull(this.r, data)
+	return data
+}
+
+func (this *snapshotReader) readString() string {
+	return string(this.readBytes())
+}
@@ -14,42 +14,85 @@
 package cache
 
 import (
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/saichler/l8types/go/ifs"
 )
 
 const DefaultSubscriptionTTL = 300 // 5 minutes
 
 // Subscription represents a user's interest in change notifications
-// for a specific model type. Keyed by AAAId (authenticated user identity).
+// for a specific model type. Keyed by AAAId (authenticated user identity) and
+// SubscriptionId, so a user may hold a subscription per browser tab or widget.
+// Subscriptions registered by Watch carry the parsed query they are filtered by.
 type Subscription struct {
-	AAAId     string
-	QueryHash int32
-	QueryText string
-	lastSeen  int64
+	AAAId          string
+	SubscriptionId string
+	QueryHash      int32
+	QueryText      string
+	// TTL is the seconds the subscription is kept without a refresh, 0 for the
+	// TTL of the cleaner.
+	TTL      int64
+	lastSeen int64
+	query    ifs.IQuery
 }
 
 // subscriptions tracks which browser tabs are subscribed to change notifications
 // for a single Cache instance (one model type). Thread-safe for concurrent access.
 type subscriptions struct {
 	mu   sync.RWMutex
-	subs map[string]*Subscription
+	subs map[string]map[string]*Subscription
 }
 
 func newSubscriptions() *subscriptions {
 	return &subscriptions{
-		subs: make(map[string]*Subscription),
+		subs: make(map[string]map[string]*Subscription),
 	}
 }
 
+// register adds the subscription, replacing the one with the same AAAId and SubscriptionId.
 func (this *subscriptions) register(sub *Subscription) {
 	this.mu.Lock()
 	defer this.mu.Unlock()
 	sub.lastSeen = time.Now().Unix()
-	this.subs[sub.AAAId] = sub
+	bySubId, ok := this.subs[sub.AAAId]
+	if !ok {
+		bySubId = make(map[string]*Subscription)
+		this.subs[sub.AAAId] = bySubId
+	}
+	bySubId[sub.SubscriptionId] = sub
+}
+
+// refresh marks the subscription as seen now. Returns false if it does not exist.
+func (this *subscriptions) refresh(aaaId, subscriptionId string) bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	sub, ok := this.subs[aaaId][subscriptionId]
+	if !ok {
+		return false
+	}
+	sub.lastSeen = time.Now().Unix()
+	return true
 }
 
-func (this *subscriptions) unregister(aaaId string) {
+// unregister removes the subscription of the AAAId with the subscription id.
+func (this *subscriptions) unregister(aaaId, subscriptionId string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	bySubId, ok := this.subs[aaaId]
+	if !ok {
+		return
+	}
+	delete(bySubId, subscriptionId)
+	if len(bySubId) == 0 {
+		delete(this.subs, aaaId)
+	}
+}
+
+// unregisterAll removes all the subscriptions of the AAAId.
+func (this *subscriptions) unregisterAll(aaaId string) {
 	this.mu.Lock()
 	defer this.mu.Unlock()
 	delete(this.subs, aaaId)
@@ -59,10 +102,43 @@ func (this *subscriptions) subscribers() []*Subscription {
 	this.mu.RLock()
 	defer this.mu.RUnlock()
 	result := make([]*Subscription, 0, len(this.subs))
-	for _, sub := range this.subs {
+	for _, bySubId := range this.subs {
+		for _, sub := range bySubId {
+			cp := *sub
+			result = append(result, &cp)
+		}
+	}
+	return result
+}
+
+// subscriptionsOf returns copies of the subscriptions of the AAAId, sorted by subscription id.
+func (this *subscriptions) subscriptionsOf(aaaId string) []*Subscription {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	bySubId := this.subs[aaaId]
+	result := make([]*Subscription, 0, len(bySubId))
+	for _, sub := range bySubId {
 		cp := *sub
 		result = append(result, &cp)
 	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].SubscriptionId < result[j].SubscriptionId
+	})
+	return result
+}
+
+// watches returns the subscriptions that are filtered by a query.
+func (this *subscriptions) watches() []*Subscription {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	var result []*Subscription
+	for _, bySubId := range this.subs {
+		for _, sub := range bySubId {
+			if sub.query != nil {
+				result = append(result, sub)
+			}
+		}
+	}
 	return result
 }
 
@@ -72,17 +148,26 @@ func (this *subscriptions) hasSubscribers() bool {
 	return len(this.subs) > 0
 }
 
-// evictStale removes subscriptions not refreshed within ttlSeconds.
-// Returns the number of evicted subscriptions.
+// evictStale removes subscriptions not refreshed within their TTL, or ttlSeconds for
+// subscriptions without one. Returns the number of evicted subscriptions.
 func (this *subscriptions) evictStale(ttlSeconds int64) int {
 	this.mu.Lock()
 	defer this.mu.Unlock()
 	now := time.Now().Unix()
 	removed := 0
-	for aaaId, sub := range this.subs {
-		if now-sub.lastSeen > ttlSeconds {
+	for aaaId, bySubId := range this.subs {
+		for subId, sub := range bySubId {
+			ttl := ttlSeconds
+			if sub.TTL > 0 {
+				ttl = sub.TTL
+			}
+			if now-sub.lastSeen > ttl {
+				delete(bySubId, subId)
+				removed++
+			}
+		}
+		if len(bySubId) == 0 {
 			delete(this.subs, aaaId)
-			removed++
 		}
 	}
 	return removed
@@ -27,6 +27,8 @@ const (
 func (this *Cache) QueryCount() int {
 	this.mtx.RLock()
 	defer this.mtx.RUnlock()
+	this.iCache.viewsMtx.Lock()
+	defer this.iCache.viewsMtx.Unlock()
 	return len(this.iCache.queries)
 }
 
@@ -89,10 +91,18 @@ func (t *ttlCleaner) run() {
 		case <-ticker.C:
 			t.cache.mtx.Lock()
 			removed := t.cache.iCache.cleanupQueries(t.ttl)
+			if t.cache.iCache.history != nil {
+				t.cache.iCache.history.pruneAll(time.Now().UnixNano())
+			}
 			t.cache.mtx.Unlock()
+			removed += t.cache.scans.cleanup(t.ttl)
 			if removed > 0 && t.cache.r != nil {
 				t.cache.r.Logger().Debug("TTL cleanup removed", " queries:", removed)
 			}
+			expired := t.cache.expireElements(time.Now().UnixNano())
+			if expired > 0 && t.cache.r != nil {
+				t.cache.r.Logger().Debug("TTL cleanup expired", " elements:", expired)
+			}
 			evicted := t.cache.subs.evictStale(DefaultSubscriptionTTL)
 			if evicted > 0 && t.cache.r != nil {
 				t.cache.r.Logger().Debug("TTL cleanup evicted", " subscriptions:", evicted)
@@ -0,0 +1,290 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/saichler/l8types/go/ifs"
+)
+
+// TimeBucket groups aggregate queries that group by an epoch timestamp property by
+// time buckets of the timestamps instead of their raw values.
+type TimeBucket struct {
+	// Width of the buckets, e.g. time.Minute or 5*time.Minute.
+	Width time.Duration
+	// Unit of the epoch timestamps, time.Second if zero.
+	Unit time.Duration
+	// Location whose wall clock the buckets are aligned to, UTC if nil. Matters for
+	// widths of an hour or more in zones with a non whole hour offset, and for days.
+	Location *time.Location
+	// FillEmpty adds the buckets with no elements between the first and the last
+	// bucket of the results, with zero counts and no other aggregate values.
+	FillEmpty bool
+	// MaxBuckets is the maximum number of buckets between the first and the last bucket
+	// FillEmpty fills, the aggregate fails above it. 10000 by default.
+	MaxBuckets int
+}
+
+const defaultMaxBuckets = 10000
+
+// SetTimeBucket groups the aggregate queries that group by the property, an integer
+// epoch timestamp, by the time buckets. The bucket of each group is returned as the
+// time.Time of the bucket's start in the bucket's location. A nil bucket restores the
+// grouping by the raw timestamps. Queries grouped by time buckets support the count,
+// sum, avg, min and max functions and the extended aggregates of the cache.
+func (this *Cache) SetTimeBucket(property string, bucket *TimeBucket) error {
+	name := normalizePropertyName(property, this.modelType)
+	var tb *timeBucket
+	if bucket != nil {
+		if bucket.Width <= 0 {
+			return errors.New("Time bucket width must be positive")
+		}
+		path, err := newPropertyPath(this.elemType, name)
+		if err != nil {
+			return err
+		}
+		if !isIntegerKind(path.kind()) {
+			return errors.New("Time bucket property " + property + " is not an epoch timestamp")
+		}
+		tb = &timeBucket{TimeBucket: *bucket, property: path}
+		if tb.Unit <= 0 {
+			tb.Unit = time.Second
+		}
+		if tb.Location == nil {
+			tb.Location = time.UTC
+		}
+		if tb.MaxBuckets <= 0 {
+			tb.MaxBuckets = defaultMaxBuckets
+		}
+	}
+
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	if tb == nil {
+		delete(this.iCache.timeBuckets, name)
+	} else {
+		if this.iCache.timeBuckets == nil {
+			this.iCache.timeBuckets = make(map[string]*timeBucket)
+		}
+		this.iCache.timeBuckets[name] = tb
+	}
+	// the registered aggregates may group by the property
+	for _, view := range this.iCache.aggregates {
+		view.reset(this.iCache.bucketsOf(view.query))
+		this.iCache.forEach(func(pk string, v interface{}) {
+			view.apply(pk, v)
+		})
+	}
+	return nil
+}
+
+func isIntegerKind(kind reflect.Kind) bool {
+	return isNumericKind(kind) && kind != reflect.Float32 && kind != reflect.Float64
+}
+
+type timeBucket struct {
+	TimeBucket
+	property *propertyPath
+}
+
+// start returns the start of the bucket of the timestamp, in the timestamp's unit.
+func (this *timeBucket) start(stamp int64) int64 {
+	nanos := stamp * int64(this.Unit)
+	_, offset := time.Unix(0, nanos).In(this.Location).Zone()
+	local := nanos + int64(offset)*int64(time.Second)
+	width := int64(this.Width)
+	floor := local / width * width
+	if local < 0 && floor != local {
+		floor -= width
+	}
+	return (floor - int64(offset)*int64(time.Second)) / int64(this.Unit)
+}
+
+// timeOf returns the time of a bucket start in the bucket's location.
+func (this *timeBucket) timeOf(start int64) time.Time {
+	return time.Unix(0, start*int64(this.Unit)).In(this.Location)
+}
+
+// next returns the start of the bucket following the bucket starting at t.
+func (this *timeBucket) next(t time.Time) time.Time {
+	width := this.Width
+	if width < this.Unit {
+		width = this.Unit
+	}
+	next := this.timeOf(this.start(t.Add(width).UnixNano() / int64(this.Unit)))
+	if !next.After(t) {
+		// a day longer than the width, at a daylight saving change
+		next = this.timeOf(this.start(t.Add(2*width).UnixNano() / int64(this.Unit)))
+	}
+	return next
+}
+
+// groupBuckets are the time buckets of the group by fields of a query.
+type groupBuckets map[string]*timeBucket
+
+// bucketsOf returns the time buckets of the query's group by fields, nil if none.
+func (this *internalCache) bucketsOf(q ifs.IQuery) groupBuckets {
+	if len(this.timeBuckets) == 0 {
+		return nil
+	}
+	var result groupBuckets
+	for _, field := range q.GroupBy() {
+		if tb, ok := this.timeBuckets[normalizePropertyName(field, this.modelType)]; ok {
+			if result == nil {
+				result = make(groupBuckets)
+			}
+			result[field] = tb
+		}
+	}
+	return result
+}
+
+// bucketRow replaces the timestamps of the row of a single element by the start of
+// their buckets.
+func (this groupBuckets) bucketRow(row map[string]interface{}) {
+	for field, tb := range this {
+		if f, ok := ToFloat64(row[field]); ok {
+			row[field] = tb.start(int64(f))
+		}
+	}
+}
+
+// aggregate computes the groups of the query over the elements that passed its WHERE
+// clause by their time buckets. Each element is aggregated alone and added to the group
+// of its buckets, so the elements are not copied to group them.
+func (this groupBuckets) aggregate(q ifs.IQuery, items []interface{}) ([]map[string]interface{}, error) {
+	view, err := newAggregateViewOf(q, true)
+	if err != nil {
+		return nil, err
+	}
+	view.buckets = this
+	for _, item := range items {
+		view.add(item)
+	}
+	return view.rows(), nil
+}
+
+// finish converts the bucket starts of the groups to times and adds the empty buckets
+// of the first bucket that fills them.
+func (this groupBuckets) finish(groups []map[string]interface{}, q ifs.IQuery) ([]map[string]interface{}, error) {
+	var fillField string
+	for _, field := range q.GroupBy() {
+		tb, ok := this[field]
+		if !ok {
+			continue
+		}
+		for _, group := range groups {
+			if f, ok := ToFloat64(group[field]); ok {
+				group[field] = tb.timeOf(int64(f))
+			}
+		}
+		if tb.FillEmpty && fillField == "" {
+			fillField = field
+		}
+	}
+	if fillField == "" || len(groups) == 0 {
+		return groups, nil
+	}
+	return this.fill(groups, q, fillField)
+}
+
+// fill adds the missing buckets of field between the first and the last bucket, for
+// every combination of the other group by values, and orders the groups by bucket.
+// Fails if there are more than MaxBuckets buckets between the first and the last.
+func (this groupBuckets) fill(groups []map[string]interface{}, q ifs.IQuery, field string) ([]map[string]interface{}, error) {
+	tb := this[field]
+	others := make([]string, 0, len(q.GroupBy()))
+	for _, f := range q.GroupBy() {
+		if f != field {
+			others = append(others, f)
+		}
+	}
+
+	var first, last time.Time
+	present := make(map[string]map[int64]bool)
+	series := make(map[string]map[string]interface{})
+	for _, group := range groups {
+		t, ok := group[field].(time.Time)
+		if !ok {
+			continue
+		}
+		if first.IsZero() || t.Before(first) {
+			first = t
+		}
+		if last.IsZero() || t.After(last) {
+			last = t
+		}
+		key := groupKeyOf(group, others)
+		if present[key] == nil {
+			present[key] = make(map[int64]bool)
+			series[key] = group
+		}
+		present[key][t.UnixNano()] = true
+	}
+	if first.IsZero() {
+		return groups, nil
+	}
+	width := tb.Width
+	if width < tb.Unit {
+		width = tb.Unit
+	}
+	if buckets := int64(last.Sub(first)/width) + 1; buckets > int64(tb.MaxBuckets) {
+		return nil, errors.New("Filling the empty buckets of " + field + " exceeds the limit of " +
+			strconv.Itoa(tb.MaxBuckets) + " buckets")
+	}
+
+	counts := make([]string, 0)
+	for _, agg := range q.Aggregates() {
+		kind, err := aggregateKindOf(agg)
+		extended, _, ok := extendedKindOf(agg.Function)
+		if (err == nil && kind == aggregateCount) || (ok && extended == extendedCountDistinct) {
+			counts = append(counts, agg.Alias)
+		}
+	}
+
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		sample := series[key]
+		for t := first; !t.After(last); t = tb.next(t) {
+			if present[key][t.UnixNano()] {
+				continue
+			}
+			group := make(map[string]interface{}, len(q.GroupBy())+len(counts))
+			for _, f := range others {
+				group[f] = sample[f]
+			}
+			group[field] = t
+			for _, alias := range counts {
+				group[alias] = float64(0)
+			}
+			groups = append(groups, group)
+		}
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		ti, _ := groups[i][field].(time.Time)
+		tj, _ := groups[j][field].(time.Time)
+		return ti.Before(tj)
+	})
+	return groups, nil
+}
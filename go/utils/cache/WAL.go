@@ -0,0 +1,210 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"time"
+
+	"github.com/saichler/l8srlz/go/serialize/object"
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/l8types/go/types/l8notify"
+	"github.com/saichler/l8utils/go/utils/notify"
+)
+
+// WALSyncPolicy determines when the write-ahead log is flushed to disk.
+type WALSyncPolicy int
+
+const (
+	// WALSyncAlways fsyncs after every mutation, no acknowledged mutation is lost.
+	WALSyncAlways WALSyncPolicy = iota
+	// WALSyncInterval fsyncs every SyncInterval, a crash loses at most the last interval.
+	WALSyncInterval
+	// WALSyncNone leaves flushing to the operating system.
+	WALSyncNone
+)
+
+const (
+	defaultWALSegmentSize     = 64 * 1024 * 1024
+	defaultWALCompactSegments = 8
+)
+
+// WALConfig configures the write-ahead log of a cache.
+type WALConfig struct {
+	// Dir is the directory of the log segments, one directory per cache model type.
+	Dir          string
+	SyncPolicy   WALSyncPolicy
+	SyncInterval time.Duration
+	// SegmentSize is the size in bytes at which a new segment is started, 64MB by default.
+	SegmentSize int64
+	// CompactSegments is the number of segments started since the last compaction after
+	// which the log is compacted in the background, 8 by default, negative to disable.
+	CompactSegments int
+	// CompactSize is the number of bytes written since the last compaction after which
+	// the log is compacted in the background, 0 to disable.
+	CompactSize int64
+}
+
+// NewCacheWithWAL creates a cache like NewCache with the WithWAL option, returning the
+// error of opening or replaying the log.
+func NewCacheWithWAL(sampleElement interface{}, initElements []interface{}, store ifs.IStorage, r ifs.IResources, config *WALConfig) (*Cache, error) {
+	this := newCache(sampleElement, store, r)
+	this.load(initElements)
+	err := WithWAL(config)(this)
+	if err != nil {
+		return nil, err
+	}
+	this.start()
+	return this, nil
+}
+
+// WithWAL is a NewCache option that records every Post, Put, Patch and Delete in a
+// write-ahead log, and replays the existing log on creation so a cache running without
+// a store survives a crash. Mutations are recorded as Post, Put and Delete notification
+// sets, Put records holding the whole element after the change, so replaying them is
+// idempotent. A mutation whose record could not be written is applied in memory but
+// returns the error, as it would not survive a crash. The log is compacted in the
+// background when it grew past CompactSegments or CompactSize.
+func WithWAL(config *WALConfig) CacheOption {
+	return func(this *Cache) error {
+		if config == nil || config.Dir == "" {
+			return errors.New("WAL directory is not set")
+		}
+		if this.r == nil || this.r.Registry() == nil {
+			return errors.New("Cannot replay a WAL without a registry")
+		}
+		this.r.Registry().Register(&l8notify.L8NotificationSet{})
+
+		w, err := openWAL(config, this.modelType)
+		if err != nil {
+			return err
+		}
+		err = w.replay(this.replayWAL)
+		if err == nil {
+			err = w.start()
+		}
+		if err != nil {
+			w.close()
+			return err
+		}
+		this.wal = w
+		go w.compactLoop(func() {
+			if e := this.CompactWAL(); e != nil {
+				this.r.Logger().Error("Failed to compact the WAL of ", this.modelType, ": ", e.Error())
+			}
+		})
+		return nil
+	}
+}
+
+// CompactWAL rewrites the log as the current elements of the cache into a new segment
+// and deletes the older segments, bounding the log size and replay time.
+func (this *Cache) CompactWAL() error {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	if this.wal == nil {
+		return errors.New("Cache has no WAL")
+	}
+
+	this.wal.mtx.Lock()
+	if this.wal.file == nil {
+		this.wal.mtx.Unlock()
+		return errors.New("WAL is closed")
+	}
+	err := this.wal.rotate()
+	segment := this.wal.segment
+	this.wal.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	this.iCache.forEach(func(pk string, v interface{}) {
+		if err == nil {
+			err = this.walAppend(l8notify.L8NotificationType_Put, pk, v)
+		}
+	})
+	if err == nil {
+		err = this.wal.sync()
+	}
+	if err != nil {
+		return err
+	}
+	err = this.wal.removeBefore(segment)
+	if err == nil {
+		this.wal.compacted()
+	}
+	return err
+}
+
+// walAppend records a mutation, v is the element after the change (nil for Delete).
+// It must be called with the cache write lock of pk held so the records of an element
+// are in mutation order.
+func (this *Cache) walAppend(t l8notify.L8NotificationType, pk string, v interface{}) error {
+	if this.wal == nil || !this.cacheEnabled() {
+		return nil
+	}
+	data, err := this.mutationRecord(t, pk, v)
+	if err != nil {
+		return err
+	}
+	return this.wal.append(data)
+}
+
+// mutationRecord encodes a mutation as a notification set, v is the element after the
+// change (nil for Delete).
+func (this *Cache) mutationRecord(t l8notify.L8NotificationType, pk string, v interface{}) ([]byte, error) {
+	var n *l8notify.L8NotificationSet
+	var err error
+	if t == l8notify.L8NotificationType_Delete {
+		n = notify.CreateNotificationSet(t, this.serviceName, pk, this.serviceArea, this.modelType, this.Source(), 0, this.notifySequence.Load())
+	} else {
+		n, err = notify.CreateAddNotification(v, this.serviceName, pk, this.serviceArea, this.modelType, this.Source(), 1, this.notifySequence.Load())
+		if err != nil {
+			return nil, err
+		}
+		n.Type = t
+	}
+	obj := object.NewEncode()
+	err = obj.Add(n)
+	if err != nil {
+		return nil, err
+	}
+	return obj.Data(), nil
+}
+
+func (this *Cache) replayWAL(data []byte) error {
+	v, err := object.NewDecode(data, 0, this.r.Registry()).Get()
+	if err != nil {
+		return err
+	}
+	n, ok := v.(*l8notify.L8NotificationSet)
+	if !ok {
+		return errors.New("WAL record is not a notification set")
+	}
+	this.notifySequence.Store(n.Sequence)
+	if n.Type == l8notify.L8NotificationType_Delete {
+		this.iCache.delete(n.ModelKey, "")
+		return nil
+	}
+	item, _, err := notify.ItemOf(n, this.r, false)
+	if err != nil {
+		return err
+	}
+	pk, uk, err := this.KeysFor(item)
+	if err != nil {
+		return err
+	}
+	this.iCache.put(pk, uk, item)
+	return nil
+}
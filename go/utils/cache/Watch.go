@@ -0,0 +1,299 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"sync"
+
+	"github.com/saichler/l8types/go/ifs"
+)
+
+// WatchEventKind is how a change affected the result set of a watch.
+type WatchEventKind int
+
+const (
+	// WatchEnter is an element that now matches the watch query.
+	WatchEnter WatchEventKind = iota + 1
+	// WatchLeave is an element that no longer matches the watch query, or was deleted.
+	WatchLeave
+	// WatchUpdate is a matching element that changed and still matches.
+	WatchUpdate
+	// WatchClosed is the last event of a watch whose events were not consumed, it has
+	// more than the watch queue limit undelivered. The watch is unregistered and must be
+	// registered again with Watch after a refetch, as its later events are dropped.
+	WatchClosed
+)
+
+// DefaultWatchQueueLimit is the default number of undelivered events of a watch.
+const DefaultWatchQueueLimit = 1000
+
+// WatchEvent is a change of an element in the result set of a watch. Item is the
+// element after the change, or the deleted element. It is shared by the events of
+// the same change and must not be modified.
+type WatchEvent struct {
+	AAAId          string
+	SubscriptionId string
+	QueryHash      int32
+	Kind           WatchEventKind
+	Key            string
+	Item           interface{}
+}
+
+// Watch registers a subscription of the AAAId, identified by the subscription id, that is
+// evaluated against every change: only changes of elements that enter, leave or change in
+// the query's result set, and are in the security scope of the AAAId, are notified to it.
+// Subscriptions registered with RegisterSubscription keep receiving every change.
+func (this *Cache) Watch(aaaId, subscriptionId string, q ifs.IQuery) {
+	this.subs.register(&Subscription{
+		AAAId:          aaaId,
+		SubscriptionId: subscriptionId,
+		QueryHash:      q.Hash(),
+		query:          q,
+	})
+}
+
+// OnWatchEvents sets the listener of the watch events. Events are delivered in change
+// order from a dedicated goroutine, so the listener may use the cache. A watch falling
+// behind by more than the watch queue limit is closed, see WatchClosed.
+func (this *Cache) OnWatchEvents(listener func([]*WatchEvent)) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.dispatcher().setListener(listener)
+}
+
+// SetWatchQueueLimit sets the number of undelivered events a watch may have before it is
+// closed, DefaultWatchQueueLimit by default. Zero or less is unbounded.
+func (this *Cache) SetWatchQueueLimit(limit int) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.dispatcher().setLimit(limit)
+}
+
+// dispatcher returns the watch dispatcher, creating it if needed, with the cache lock held.
+func (this *Cache) dispatcher() *watchDispatcher {
+	if this.watchDispatcher == nil {
+		this.watchDispatcher = newWatchDispatcher(this.subs.unregister)
+	}
+	return this.watchDispatcher
+}
+
+// watchState is the match of an element against every watch before a change.
+type watchState struct {
+	watches []*Subscription
+	before  []bool
+}
+
+// watchBefore evaluates the watches on an element before it is changed, v is nil for
+// a new element. Returns nil when there are no watches.
+func (this *Cache) watchBefore(v interface{}) *watchState {
+	watches := this.subs.watches()
+	if len(watches) == 0 {
+		return nil
+	}
+	state := &watchState{watches: watches, before: make([]bool, len(watches))}
+	if v != nil {
+		for i, w := range watches {
+			state.before[i] = this.watchMatch(w, v)
+		}
+	}
+	return state
+}
+
+// watchAfter evaluates the watches on the element after the change, v is nil when old
+// was deleted, dispatches the events and returns the AAAIds of the affected watches.
+func (this *Cache) watchAfter(state *watchState, pk string, old, v interface{}) map[string]bool {
+	if state == nil {
+		return nil
+	}
+	affected := make(map[string]bool)
+	var events []*WatchEvent
+	var itemClone interface{}
+	for i, w := range state.watches {
+		after := v != nil && this.watchMatch(w, v)
+		var kind WatchEventKind
+		switch {
+		case after && !state.before[i]:
+			kind = WatchEnter
+		case !after && state.before[i]:
+			kind = WatchLeave
+		case after:
+			kind = WatchUpdate
+		default:
+			continue
+		}
+		affected[w.AAAId] = true
+		if this.watchDispatcher == nil {
+			continue
+		}
+		if itemClone == nil {
+			if v != nil {
+				itemClone = this.readCopy(v)
+			} else if old != nil {
+				itemClone = this.readCopy(old)
+			}
+		}
+		events = append(events, &WatchEvent{AAAId: w.AAAId, SubscriptionId: w.SubscriptionId, QueryHash: w.QueryHash, Kind: kind, Key: pk, Item: itemClone})
+	}
+	if len(events) > 0 {
+		if this.watchHeld != nil {
+			this.watchHeld = append(this.watchHeld, events)
+		} else {
+			this.watchDispatcher.dispatch(events)
+		}
+	}
+	return affected
+}
+
+// holdWatchEvents holds the watch events of the following changes until they are
+// released, so events of changes that are rolled back are never delivered.
+func (this *Cache) holdWatchEvents() {
+	this.watchHeld = make([][]*WatchEvent, 0)
+}
+
+// releaseWatchEvents dispatches the held events, or drops them if discard is true.
+func (this *Cache) releaseWatchEvents(discard bool) {
+	held := this.watchHeld
+	this.watchHeld = nil
+	if discard || this.watchDispatcher == nil {
+		return
+	}
+	for _, events := range held {
+		this.watchDispatcher.dispatch(events)
+	}
+}
+
+func (this *Cache) watchMatch(w *Subscription, v interface{}) bool {
+	return w.query.Match(v) && inScope(this.r, v, w.AAAId)
+}
+
+// watchDispatcher delivers watch events to the listener in order without blocking
+// the mutations that produced them. The undelivered events of every watch are counted,
+// a watch reaching the limit is closed with onClose so it can't grow the queue.
+type watchDispatcher struct {
+	mtx      sync.Mutex
+	cond     *sync.Cond
+	queue    [][]*WatchEvent
+	listener func([]*WatchEvent)
+	running  bool
+	closed   bool
+	limit    int
+	pending  map[string]int
+	closing  map[string]bool
+	onClose  func(aaaId, subscriptionId string)
+}
+
+func newWatchDispatcher(onClose func(aaaId, subscriptionId string)) *watchDispatcher {
+	d := &watchDispatcher{limit: DefaultWatchQueueLimit, onClose: onClose}
+	d.cond = sync.NewCond(&d.mtx)
+	d.pending = make(map[string]int)
+	d.closing = make(map[string]bool)
+	return d
+}
+
+func watchKey(aaaId, subscriptionId string) string {
+	return aaaId + "\x00" + subscriptionId
+}
+
+func (this *watchDispatcher) setLimit(limit int) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.limit = limit
+}
+
+func (this *watchDispatcher) setListener(listener func([]*WatchEvent)) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.listener = listener
+	if !this.running && !this.closed {
+		this.running = true
+		go this.run()
+	}
+}
+
+func (this *watchDispatcher) dispatch(events []*WatchEvent) {
+	this.mtx.Lock()
+	if this.listener == nil || this.closed {
+		this.mtx.Unlock()
+		return
+	}
+	queued := make([]*WatchEvent, 0, len(events))
+	var closedWatches []*WatchEvent
+	for _, event := range events {
+		key := watchKey(event.AAAId, event.SubscriptionId)
+		if this.closing[key] {
+			continue
+		}
+		if this.limit > 0 && this.pending[key] >= this.limit {
+			this.closing[key] = true
+			event = &WatchEvent{AAAId: event.AAAId, SubscriptionId: event.SubscriptionId, QueryHash: event.QueryHash, Kind: WatchClosed}
+			closedWatches = append(closedWatches, event)
+		}
+		this.pending[key]++
+		queued = append(queued, event)
+	}
+	if len(queued) > 0 {
+		this.queue = append(this.queue, queued)
+		this.cond.Signal()
+	}
+	this.mtx.Unlock()
+	if this.onClose != nil {
+		for _, event := range closedWatches {
+			this.onClose(event.AAAId, event.SubscriptionId)
+		}
+	}
+}
+
+// delivered releases the queue slots of delivered events, with the lock held. A closed
+// watch accepts events again once all of its events were delivered.
+func (this *watchDispatcher) delivered(events []*WatchEvent) {
+	for _, event := range events {
+		key := watchKey(event.AAAId, event.SubscriptionId)
+		this.pending[key]--
+		if this.pending[key] <= 0 {
+			delete(this.pending, key)
+			delete(this.closing, key)
+		}
+	}
+}
+
+func (this *watchDispatcher) run() {
+	for {
+		this.mtx.Lock()
+		for len(this.queue) == 0 && !this.closed {
+			this.cond.Wait()
+		}
+		if this.closed {
+			this.mtx.Unlock()
+			return
+		}
+		events := this.queue[0]
+		this.queue = this.queue[1:]
+		this.delivered(events)
+		listener := this.listener
+		this.mtx.Unlock()
+		if listener != nil {
+			listener(events)
+		}
+	}
+}
+
+func (this *watchDispatcher) close() {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.closed = true
+	this.queue = nil
+	this.pending = make(map[string]int)
+	this.closing = make(map[string]bool)
+	this.cond.Broadcast()
+}
@@ -0,0 +1,632 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"container/list"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/saichler/l8reflect/go/reflect/updating"
+	"github.com/saichler/l8srlz/go/serialize/object"
+	"github.com/saichler/l8types/go/types/l8notify"
+	"github.com/saichler/l8utils/go/utils/notify"
+)
+
+const (
+	defaultWriteBehindBatchSize     = 100
+	defaultWriteBehindFlushInterval = 100 * time.Millisecond
+	defaultWriteBehindRetryBackoff  = 100 * time.Millisecond
+	defaultWriteBehindMaxBackoff    = 30 * time.Second
+	defaultWriteBehindMaxAttempts   = 10
+	defaultWriteBehindCompaction    = 1000
+	// writeBehindAck is the model type of the outbox records of the writes that left the
+	// queue, flushed or dropped, so they are not replayed
+	writeBehindAck = "write-behind-ack"
+)
+
+// BatchStorage is implemented by stores that write several elements in one call. The
+// write-behind flushes send each batch to WriteBatch instead of writing its elements one
+// by one.
+type BatchStorage interface {
+	// WriteBatch writes the values by key, deleting the keys whose value is nil. A batch
+	// that fails is retried as a whole, so it is either written or failed as a whole.
+	WriteBatch(keys []string, values []interface{}) error
+}
+
+// WriteBehindConfig configures writing the mutations of a cache to its store in the
+// background instead of under the cache lock.
+type WriteBehindConfig struct {
+	// Dir is the directory of the durable queue of the writes not yet in the store, one
+	// directory per cache model type. Writes queued before a crash are replayed.
+	Dir string
+	// SyncPolicy determines when the queue is flushed to disk, see WALSyncPolicy.
+	SyncPolicy   WALSyncPolicy
+	SyncInterval time.Duration
+	// BatchSize is the maximum number of store writes of a flush, 100 by default. They are
+	// written in one call when the store is a BatchStorage.
+	BatchSize int
+	// FlushInterval is the time between flushes, 100ms by default.
+	FlushInterval time.Duration
+	// RetryBackoff is the delay before retrying after a failed store write, doubled on
+	// every consecutive failure up to MaxBackoff. 100ms and 30s by default.
+	RetryBackoff time.Duration
+	MaxBackoff   time.Duration
+	// MaxAttempts is the number of failed store writes of a key after which its write is
+	// dropped from the queue and handed to DeadLetter, 10 by default. A failed write is
+	// retried after the other queued writes, so it does not hold them back.
+	MaxAttempts int
+	// DeadLetter, if set, is called with the dropped writes, value is nil for a delete.
+	DeadLetter func(pk string, value interface{}, err error)
+	// CompactThreshold is the number of records of writes already in the store, or
+	// replaced by later writes of their key, after which the queue is rewritten with only
+	// the pending writes, 1000 by default. An emptied queue is always compacted.
+	CompactThreshold int
+}
+
+// ReconcileReport holds the keys whose elements in the cache and in the store differ.
+type ReconcileReport struct {
+	// Different are the keys whose cache and store elements are not equal.
+	Different []string
+	// MissingInStore are the keys of cache elements that are not in the store.
+	MissingInStore []string
+	// MissingInCache are the keys of store elements that are not in the cache.
+	MissingInCache []string
+}
+
+// Consistent returns true if the cache and the store hold the same elements.
+func (this *ReconcileReport) Consistent() bool {
+	return len(this.Different) == 0 && len(this.MissingInStore) == 0 && len(this.MissingInCache) == 0
+}
+
+// SetWriteBehind makes Post, Put, Patch and Delete queue their store writes in a durable
+// queue and return, the writes are flushed to the store in the background, in batches,
+// retrying failed writes with backoff up to MaxAttempts times. Only the last write of a
+// key is kept in the queue. The writes queued before a restart are replayed into the
+// cache, which was loaded from the store they did not reach, and flushed.
+// The store does not reflect a mutation until it is flushed, and writes of different
+// keys may reach it in a different order than they were applied. A nil config flushes
+// the queue and writes synchronously again, it fails and write-behind stays set while
+// writes are still pending, e.g. the store is down. Requires a store with the cache
+// enabled.
+func (this *Cache) SetWriteBehind(config *WriteBehindConfig) error {
+	if config == nil {
+		return this.disableWriteBehind()
+	}
+	if this.store == nil || !this.store.CacheEnabled() {
+		return errors.New("Write-behind requires a store with cache enabled")
+	}
+	if config.Dir == "" {
+		return errors.New("Write-behind directory is not set")
+	}
+	if this.r == nil || this.r.Registry() == nil {
+		return errors.New("Cannot replay the write-behind queue without a registry")
+	}
+	this.r.Registry().Register(&l8notify.L8NotificationSet{})
+
+	outbox, err := openWAL(&WALConfig{Dir: config.Dir, SyncPolicy: config.SyncPolicy, SyncInterval: config.SyncInterval}, this.modelType+"-outbox")
+	if err != nil {
+		return err
+	}
+	wb := newWriteBehind(this, config, outbox)
+	err = outbox.replay(wb.replay)
+	if err == nil {
+		err = outbox.start()
+	}
+	if err != nil {
+		outbox.close()
+		return err
+	}
+
+	this.mtx.Lock()
+	if this.writeBehind != nil {
+		this.mtx.Unlock()
+		outbox.close()
+		return errors.New("Write-behind is already set")
+	}
+	this.applyReplayed(wb)
+	this.writeBehind = wb
+	this.mtx.Unlock()
+	go wb.run()
+	return nil
+}
+
+// disableWriteBehind flushes the queued writes and detaches the write-behind. Pending
+// writes would be replayed over the later synchronous writes of their keys when
+// write-behind is set again, so it fails while writes are pending.
+func (this *Cache) disableWriteBehind() error {
+	this.mtx.RLock()
+	wb := this.writeBehind
+	this.mtx.RUnlock()
+	if wb == nil {
+		return nil
+	}
+	if err := wb.flushAll(); err != nil {
+		return errors.New("Write-behind has pending writes, failed to flush them: " + err.Error())
+	}
+	this.mtx.Lock()
+	if this.writeBehind != wb {
+		this.mtx.Unlock()
+		return nil
+	}
+	if wb.pendingCount() > 0 {
+		this.mtx.Unlock()
+		return errors.New("Write-behind has pending writes, queued during its flush")
+	}
+	this.writeBehind = nil
+	this.mtx.Unlock()
+	return wb.close()
+}
+
+// closeWriteBehind detaches the write-behind and closes it. Writes that could not be
+// flushed stay in its outbox and are replayed on the next start.
+func (this *Cache) closeWriteBehind() error {
+	this.mtx.Lock()
+	wb := this.writeBehind
+	this.writeBehind = nil
+	this.mtx.Unlock()
+	if wb == nil {
+		return nil
+	}
+	return wb.close()
+}
+
+// applyReplayed applies the writes replayed from the outbox to the cache, which was
+// loaded from the store they did not reach yet, with the cache write lock held.
+func (this *Cache) applyReplayed(wb *writeBehind) {
+	if !this.cacheEnabled() {
+		return
+	}
+	for e := wb.order.Front(); e != nil; e = e.Next() {
+		write := wb.pending[e.Value.(string)]
+		if write.value == nil {
+			this.iCache.delete(write.pk, "")
+			continue
+		}
+		_, uk, _ := this.KeysFor(write.value)
+		v := cloner.Clone(write.value)
+		this.iCache.put(write.pk, uk, v)
+		this.iCache.expiries.set(write.pk, this.expiryOf(v, 0))
+	}
+}
+
+// PendingWrites returns the number of keys whose writes are not yet in the store.
+func (this *Cache) PendingWrites() int {
+	this.mtx.RLock()
+	wb := this.writeBehind
+	this.mtx.RUnlock()
+	if wb == nil {
+		return 0
+	}
+	return wb.pendingCount()
+}
+
+// FlushWrites writes all the queued writes to the store now, returning the first error.
+func (this *Cache) FlushWrites() error {
+	this.mtx.RLock()
+	wb := this.writeBehind
+	this.mtx.RUnlock()
+	if wb == nil {
+		return nil
+	}
+	return wb.flushAll()
+}
+
+// Reconcile compares the elements of the cache with the elements of the store and
+// reports the keys whose copies differ. Keys with writes still queued are skipped.
+func (this *Cache) Reconcile() (*ReconcileReport, error) {
+	if this.store == nil {
+		return nil, errors.New("Cache has no store to reconcile with")
+	}
+	if !this.store.CacheEnabled() {
+		return nil, errors.New("Cache is disabled, the store is the only copy")
+	}
+
+	unlock := this.sharedLock()
+	cached := make(map[string]interface{}, this.iCache.size())
+	this.iCache.forEach(func(pk string, v interface{}) {
+		cached[pk] = this.readCopy(v)
+	})
+	wb := this.writeBehind
+	unlock()
+
+	stored := this.collectStore(allElementsInCache)
+	report := &ReconcileReport{}
+	for pk, v := range cached {
+		if wb != nil && wb.isPending(pk) {
+			continue
+		}
+		s, ok := stored[pk]
+		if !ok || s == nil {
+			report.MissingInStore = append(report.MissingInStore, pk)
+			continue
+		}
+		updater := updating.NewUpdater(this.r, true, true)
+		if err := updater.DryUpdate(s, v); err != nil {
+			return nil, err
+		}
+		if len(updater.Changes()) > 0 {
+			report.Different = append(report.Different, pk)
+		}
+	}
+	for pk := range stored {
+		if _, ok := cached[pk]; ok {
+			continue
+		}
+		if wb != nil && wb.isPending(pk) {
+			continue
+		}
+		report.MissingInCache = append(report.MissingInCache, pk)
+	}
+	sort.Strings(report.Different)
+	sort.Strings(report.MissingInStore)
+	sort.Strings(report.MissingInCache)
+	return report, nil
+}
+
+// storePut writes an element to the store, or queues the write in write-behind mode.
+// It must be called with the cache write lock of pk held.
+func (this *Cache) storePut(pk string, v interface{}) error {
+	if this.writeBehind != nil {
+		return this.writeBehind.enqueue(pk, this.readCopy(v))
+	}
+	return this.writeStore(pk, v)
+}
+
+// storeDelete deletes an element from the store, or queues the delete in write-behind
+// mode, returning the deleted element, item, the cached element, if the delete was queued.
+// It must be called with the cache write lock of pk held.
+func (this *Cache) storeDelete(pk string, item interface{}) (interface{}, error) {
+	if this.writeBehind != nil {
+		return item, this.writeBehind.enqueue(pk, nil)
+	}
+	return this.deleteFromStore(pk)
+}
+
+// storeWrite is a queued write of an element, value is nil for a delete. record is
+// its encoded outbox record, elem its position in the queue and attempts the number of
+// times writing it to the store failed.
+type storeWrite struct {
+	pk       string
+	value    interface{}
+	record   []byte
+	elem     *list.Element
+	attempts int
+}
+
+type writeBehind struct {
+	cache  *Cache
+	config WriteBehindConfig
+	outbox *wal
+
+	mtx     sync.Mutex
+	pending map[string]*storeWrite
+	order   *list.List
+	// stale counts the outbox records of writes no longer pending
+	stale int
+	// flushing serializes the flushes of the background loop and FlushWrites
+	flushing sync.Mutex
+	failures int
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newWriteBehind(cache *Cache, config *WriteBehindConfig, outbox *wal) *writeBehind {
+	wb := &writeBehind{cache: cache, config: *config, outbox: outbox,
+		pending: make(map[string]*storeWrite), order: list.New(), stop: make(chan struct{}), done: make(chan struct{})}
+	if wb.config.BatchSize <= 0 {
+		wb.config.BatchSize = defaultWriteBehindBatchSize
+	}
+	if wb.config.FlushInterval <= 0 {
+		wb.config.FlushInterval = defaultWriteBehindFlushInterval
+	}
+	if wb.config.RetryBackoff <= 0 {
+		wb.config.RetryBackoff = defaultWriteBehindRetryBackoff
+	}
+	if wb.config.MaxBackoff <= 0 {
+		wb.config.MaxBackoff = defaultWriteBehindMaxBackoff
+	}
+	if wb.config.MaxAttempts <= 0 {
+		wb.config.MaxAttempts = defaultWriteBehindMaxAttempts
+	}
+	if wb.config.CompactThreshold <= 0 {
+		wb.config.CompactThreshold = defaultWriteBehindCompaction
+	}
+	return wb
+}
+
+// enqueue records the write in the outbox and queues it, replacing a queued write of
+// the same key.
+func (this *writeBehind) enqueue(pk string, value interface{}) error {
+	data, err := this.cache.mutationRecord(this.recordType(value), pk, value)
+	if err != nil {
+		return err
+	}
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	err = this.outbox.append(data)
+	if err != nil {
+		return err
+	}
+	this.queue(&storeWrite{pk: pk, value: value, record: data})
+	return nil
+}
+
+func (this *writeBehind) recordType(value interface{}) l8notify.L8NotificationType {
+	if value == nil {
+		return l8notify.L8NotificationType_Delete
+	}
+	return l8notify.L8NotificationType_Put
+}
+
+// queue adds the write to the pending writes, replacing a queued write of the key in
+// its position, with mtx held.
+func (this *writeBehind) queue(write *storeWrite) {
+	if queued, ok := this.pending[write.pk]; ok {
+		write.elem = queued.elem
+		this.stale++
+	} else {
+		write.elem = this.order.PushBack(write.pk)
+	}
+	this.pending[write.pk] = write
+}
+
+// remove removes the write from the pending writes, with mtx held.
+func (this *writeBehind) remove(write *storeWrite) {
+	delete(this.pending, write.pk)
+	this.order.Remove(write.elem)
+	this.stale++
+}
+
+// acknowledge removes a write that left the queue, flushed or dropped, and records it in
+// the outbox so it is not replayed, with mtx held. The write is the last one of its key
+// in the outbox, a later write of the key would have replaced it.
+func (this *writeBehind) acknowledge(write *storeWrite) error {
+	this.remove(write)
+	n := notify.CreateNotificationSet(l8notify.L8NotificationType_Delete, this.cache.serviceName, write.pk,
+		this.cache.serviceArea, writeBehindAck, this.cache.Source(), 0, 0)
+	obj := object.NewEncode()
+	if err := obj.Add(n); err != nil {
+		return err
+	}
+	this.stale++
+	return this.outbox.append(obj.Data())
+}
+
+func (this *writeBehind) pendingCount() int {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	return len(this.pending)
+}
+
+// replay queues a write recorded in the outbox before a restart.
+func (this *writeBehind) replay(data []byte) error {
+	v, err := object.NewDecode(data, 0, this.cache.r.Registry()).Get()
+	if err != nil {
+		return err
+	}
+	n, ok := v.(*l8notify.L8NotificationSet)
+	if !ok {
+		return errors.New("Write-behind record is not a notification set")
+	}
+	if n.ModelType == writeBehindAck {
+		if queued, ok := this.pending[n.ModelKey]; ok {
+			this.remove(queued)
+		}
+		this.stale++
+		return nil
+	}
+	write := &storeWrite{pk: n.ModelKey, record: data}
+	if n.Type != l8notify.L8NotificationType_Delete {
+		write.value, _, err = notify.ItemOf(n, this.cache.r, false)
+		if err != nil {
+			return err
+		}
+	}
+	this.queue(write)
+	return nil
+}
+
+// pendingValue returns the queued element of the key, nil for a queued delete, and
+// false if no write of the key is queued.
+func (this *writeBehind) pendingValue(pk string) (interface{}, bool) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	write, ok := this.pending[pk]
+	if !ok {
+		return nil, false
+	}
+	return write.value, true
+}
+
+func (this *writeBehind) isPending(pk string) bool {
+	_, ok := this.pendingValue(pk)
+	return ok
+}
+
+func (this *writeBehind) run() {
+	defer close(this.done)
+	delay := this.config.FlushInterval
+	for {
+		select {
+		case <-this.stop:
+			return
+		case <-time.After(delay):
+		}
+		err := this.flush()
+		if err == nil {
+			this.failures = 0
+			delay = this.config.FlushInterval
+			continue
+		}
+		this.failures++
+		delay = this.config.RetryBackoff << uint(this.failures-1)
+		if delay <= 0 || delay > this.config.MaxBackoff {
+			delay = this.config.MaxBackoff
+		}
+		if this.cache.r != nil {
+			this.cache.r.Logger().Error("Failed to write behind to the store of ", this.cache.modelType,
+				", retrying in ", delay.String(), ": ", err.Error())
+		}
+	}
+}
+
+// flush writes up to a batch of the queued writes to the store, returning the first
+// error. Failed writes stay queued and are moved behind the other writes, until they
+// failed MaxAttempts times and are dropped.
+func (this *writeBehind) flush() error {
+	this.flushing.Lock()
+	defer this.flushing.Unlock()
+
+	this.mtx.Lock()
+	batch := make([]*storeWrite, 0, this.config.BatchSize)
+	for e := this.order.Front(); e != nil && len(batch) < this.config.BatchSize; e = e.Next() {
+		batch = append(batch, this.pending[e.Value.(string)])
+	}
+	this.mtx.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	errs := this.write(batch)
+	var err error
+	for _, e := range errs {
+		if e != nil {
+			err = e
+			break
+		}
+	}
+
+	this.mtx.Lock()
+	var dropped []int
+	for i, write := range batch {
+		// a later write of the key was queued during the flush
+		if this.pending[write.pk] != write {
+			continue
+		}
+		if errs[i] != nil {
+			write.attempts++
+			if write.attempts < this.config.MaxAttempts {
+				this.order.MoveToBack(write.elem)
+				continue
+			}
+			dropped = append(dropped, i)
+		}
+		if e := this.acknowledge(write); e != nil && err == nil {
+			err = e
+		}
+	}
+	if this.stale >= this.config.CompactThreshold || (this.stale > 0 && this.order.Len() == 0) {
+		if e := this.compact(); e != nil && err == nil {
+			err = e
+		}
+	}
+	this.mtx.Unlock()
+
+	for _, i := range dropped {
+		this.deadLetter(batch[i], errs[i])
+	}
+	return err
+}
+
+// write writes a batch to the store, in one call when it is a BatchStorage, returning
+// the error of each write.
+func (this *writeBehind) write(batch []*storeWrite) []error {
+	errs := make([]error, len(batch))
+	if bs, ok := this.cache.store.(BatchStorage); ok {
+		keys := make([]string, len(batch))
+		values := make([]interface{}, len(batch))
+		for i, write := range batch {
+			keys[i] = write.pk
+			values[i] = write.value
+		}
+		if err := this.cache.writeStoreBatch(bs, keys, values); err != nil {
+			for i := range errs {
+				errs[i] = err
+			}
+		}
+		return errs
+	}
+	for i, write := range batch {
+		if write.value == nil {
+			_, errs[i] = this.cache.deleteFromStore(write.pk)
+		} else {
+			errs[i] = this.cache.writeStore(write.pk, write.value)
+		}
+	}
+	return errs
+}
+
+// deadLetter reports a write dropped after failing MaxAttempts times.
+func (this *writeBehind) deadLetter(write *storeWrite, err error) {
+	if this.cache.r != nil {
+		this.cache.r.Logger().Error("Dropped the write behind of ", write.pk, " to the store of ",
+			this.cache.modelType, " after ", write.attempts, " failed attempts: ", err.Error())
+	}
+	if this.config.DeadLetter != nil {
+		this.config.DeadLetter(write.pk, write.value, err)
+	}
+}
+
+// flushAll flushes until the queue is empty or a write fails.
+func (this *writeBehind) flushAll() error {
+	for {
+		this.mtx.Lock()
+		empty := len(this.pending) == 0
+		this.mtx.Unlock()
+		if empty {
+			return nil
+		}
+		if err := this.flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// compact starts a new outbox segment holding only the pending writes and deletes the
+// older segments, with mtx held. It rewrites all the pending writes, so it runs only
+// when enough records are stale, see CompactThreshold.
+func (this *writeBehind) compact() error {
+	this.outbox.mtx.Lock()
+	err := this.outbox.rotate()
+	segment := this.outbox.segment
+	this.outbox.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+	for e := this.order.Front(); e != nil; e = e.Next() {
+		if err := this.outbox.append(this.pending[e.Value.(string)].record); err != nil {
+			return err
+		}
+	}
+	this.stale = 0
+	if err = this.outbox.sync(); err != nil {
+		return err
+	}
+	return this.outbox.removeBefore(segment)
+}
+
+// close stops the background flushes, flushes the queue and closes the outbox. Writes
+// that failed stay in the outbox, see closeWriteBehind.
+func (this *writeBehind) close() error {
+	close(this.stop)
+	<-this.done
+	err := this.flushAll()
+	if e := this.outbox.close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,285 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/saichler/l8types/go/ifs"
+	"github.com/saichler/l8types/go/types/l8api"
+)
+
+type aggregateKind int
+
+const (
+	aggregateCount aggregateKind = iota
+	aggregateSum
+	aggregateAvg
+	aggregateMin
+	aggregateMax
+)
+
+func aggregateKindOf(agg *l8api.L8AggregateFunction) (aggregateKind, error) {
+	switch strings.ToLower(agg.Function) {
+	case "count":
+		return aggregateCount, nil
+	case "sum":
+		return aggregateSum, nil
+	case "avg":
+		return aggregateAvg, nil
+	case "min":
+		return aggregateMin, nil
+	case "max":
+		return aggregateMax, nil
+	}
+	return 0, errors.New("Aggregate function " + agg.Function + " cannot be maintained incrementally")
+}
+
+// aggregateState is the running value of one aggregate function of a group. values
+// counts the contributed values of min and max so they can be retracted.
+type aggregateState struct {
+	count  int
+	sum    float64
+	values map[float64]int
+	min    float64
+	max    float64
+}
+
+func (this *aggregateState) add(kind aggregateKind, value float64) {
+	this.count++
+	this.sum += value
+	if kind != aggregateMin && kind != aggregateMax {
+		return
+	}
+	if this.values == nil {
+		this.values = make(map[float64]int)
+	}
+	this.values[value]++
+	if this.count == 1 || value < this.min {
+		this.min = value
+	}
+	if this.count == 1 || value > this.max {
+		this.max = value
+	}
+}
+
+func (this *aggregateState) retract(kind aggregateKind, value float64) {
+	this.count--
+	this.sum -= value
+	if kind != aggregateMin && kind != aggregateMax {
+		return
+	}
+	this.values[value]--
+	if this.values[value] > 0 {
+		return
+	}
+	delete(this.values, value)
+	if (kind == aggregateMin && value == this.min) || (kind == aggregateMax && value == this.max) {
+		// the extreme was retracted, find the next one
+		first := true
+		for v := range this.values {
+			if first || v < this.min {
+				this.min = v
+			}
+			if first || v > this.max {
+				this.max = v
+			}
+			first = false
+		}
+	}
+}
+
+func (this *aggregateState) value(kind aggregateKind) (float64, bool) {
+	switch kind {
+	case aggregateCount, aggregateSum:
+		return this.sum, true
+	case aggregateAvg:
+		if this.count == 0 {
+			return 0, false
+		}
+		return this.sum / float64(this.count), true
+	case aggregateMin:
+		return this.min, this.count > 0
+	case aggregateMax:
+		return this.max, this.count > 0
+	}
+	return 0, false
+}
+
+// aggregateGroup is a group of an aggregate view, keys are its group by values.
+type aggregateGroup struct {
+	keys   map[string]interface{}
+	size   int
+	states []aggregateState
+}
+
+// aggregateMember is the contribution of an element to its group, kept so it is
+// retracted as it was added even after the element is patched in place.
+type aggregateMember struct {
+	group  string
+	values []float64
+	valid  []bool
+}
+
+// aggregateView maintains the groups of a registered aggregate query incrementally
+// on every change of the cache, so fetching it costs the number of groups.
+type aggregateView struct {
+	query      ifs.IQuery
+	aliases    []string
+	kinds      []aggregateKind
+	groupBy    []string
+	groups     map[string]*aggregateGroup
+	members    map[string]*aggregateMember
+	groupOrder []string
+	buckets    groupBuckets
+}
+
+func newAggregateView(q ifs.IQuery) (*aggregateView, error) {
+	return newAggregateViewOf(q, false)
+}
+
+// newAggregateViewOf creates the view of the query, without its extended aggregates
+// if skipExtended, they are computed on the elements, see addExtendedAggregates.
+func newAggregateViewOf(q ifs.IQuery, skipExtended bool) (*aggregateView, error) {
+	if !q.IsAggregate() {
+		return nil, errors.New("Query is not an aggregate query")
+	}
+	view := &aggregateView{
+		query:   q,
+		groupBy: q.GroupBy(),
+		groups:  make(map[string]*aggregateGroup),
+		members: make(map[string]*aggregateMember),
+	}
+	for _, agg := range q.Aggregates() {
+		kind, err := aggregateKindOf(agg)
+		if err != nil {
+			if _, _, extended := extendedKindOf(agg.Function); skipExtended && extended {
+				continue
+			}
+			return nil, err
+		}
+		view.aliases = append(view.aliases, agg.Alias)
+		view.kinds = append(view.kinds, kind)
+	}
+	return view, nil
+}
+
+// apply updates the view with the change of an element, value is nil when the
+// element was deleted.
+func (this *aggregateView) apply(pk string, value interface{}) {
+	this.retract(pk)
+	if value == nil || len(this.query.Filter([]interface{}{value}, false)) == 0 {
+		return
+	}
+	if member := this.add(value); member != nil {
+		this.members[pk] = member
+	}
+}
+
+// add adds an element that passed the WHERE clause to its group, returning its
+// contribution. Its timestamps are grouped by the bucket of their value.
+func (this *aggregateView) add(value interface{}) *aggregateMember {
+	rows := this.query.Aggregate([]interface{}{value})
+	if len(rows) == 0 {
+		return nil
+	}
+	row := rows[0]
+	this.buckets.bucketRow(row)
+	member := &aggregateMember{
+		group:  groupKeyOf(row, this.groupBy),
+		values: make([]float64, len(this.aliases)),
+		valid:  make([]bool, len(this.aliases)),
+	}
+	group, ok := this.groups[member.group]
+	if !ok {
+		group = &aggregateGroup{keys: make(map[string]interface{}, len(this.groupBy)), states: make([]aggregateState, len(this.aliases))}
+		for _, field := range this.groupBy {
+			group.keys[field] = row[field]
+		}
+		this.groups[member.group] = group
+		this.groupOrder = append(this.groupOrder, member.group)
+	}
+	group.size++
+	for i, alias := range this.aliases {
+		member.values[i], member.valid[i] = ToFloat64(row[alias])
+		if member.valid[i] {
+			group.states[i].add(this.kinds[i], member.values[i])
+		}
+	}
+	return member
+}
+
+// reset clears the view for its elements to be applied again with the time buckets.
+func (this *aggregateView) reset(buckets groupBuckets) {
+	this.groups = make(map[string]*aggregateGroup)
+	this.members = make(map[string]*aggregateMember)
+	this.groupOrder = nil
+	this.buckets = buckets
+}
+
+func (this *aggregateView) retract(pk string) {
+	member, ok := this.members[pk]
+	if !ok {
+		return
+	}
+	delete(this.members, pk)
+	group := this.groups[member.group]
+	group.size--
+	if group.size == 0 {
+		delete(this.groups, member.group)
+		for i, key := range this.groupOrder {
+			if key == member.group {
+				this.groupOrder = append(this.groupOrder[:i], this.groupOrder[i+1:]...)
+				break
+			}
+		}
+		return
+	}
+	for i, valid := range member.valid {
+		if valid {
+			group.states[i].retract(this.kinds[i], member.values[i])
+		}
+	}
+}
+
+// rows returns the groups in the shape of IQuery.Aggregate.
+func (this *aggregateView) rows() []map[string]interface{} {
+	if len(this.groups) == 0 && len(this.groupBy) == 0 {
+		// the single group of no elements, as the query computes it
+		return this.query.Aggregate([]interface{}{})
+	}
+	result := make([]map[string]interface{}, 0, len(this.groups))
+	for _, key := range this.groupOrder {
+		group := this.groups[key]
+		row := make(map[string]interface{}, len(group.keys)+len(this.aliases))
+		for field, v := range group.keys {
+			row[field] = v
+		}
+		for i, alias := range this.aliases {
+			if v, ok := group.states[i].value(this.kinds[i]); ok {
+				row[alias] = v
+			}
+		}
+		result = append(result, row)
+	}
+	return result
+}
+
+// aggregatesChanged updates the registered aggregate views with a change.
+func (this *internalCache) aggregatesChanged(pk string, value interface{}) {
+	for _, view := range this.aggregates {
+		view.apply(pk, value)
+	}
+}
@@ -14,29 +14,51 @@
 package cache
 
 import (
+	"reflect"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/saichler/l8reflect/go/reflect/updating"
 	"github.com/saichler/l8types/go/ifs"
 	"github.com/saichler/l8types/go/types/l8api"
 )
 
 type internalCache struct {
-	cache           map[string]interface{}
-	UniqueToPrimary map[string]string
-	PrimaryToUnique map[string]string
-	hasExtraKeys    bool
+	shards          []*cacheShard
+	residents       atomic.Int64
 	stamp           int64
 	queries         map[int64]*internalQuery
 	metadataFunc    map[string]func(interface{}) (bool, string)
+	indexes         map[string]*propertyPath
+	modelType       string
+	elemType        reflect.Type
+	eviction        *eviction
+	evicted         map[string]bool
+	expiries        *expiries
+	revision        atomic.Uint64
+	history         *history
+	aggregates      map[int32]*aggregateView
+	histogramBounds map[string][]float64
+	timeBuckets     map[string]*timeBucket
+
+	// viewsMtx guards the stamp, the prepared queries and the aggregate views, which
+	// the single key writes of all the shards update
+	viewsMtx *sync.Mutex
+
+	// indexedFetches counts the query preparations narrowed by an index
+	indexedFetches    atomic.Uint64
+	indexBypassLogged atomic.Bool
 }
 
-func newInternalCache() *internalCache {
-	iq := &internalCache{}
-	iq.cache = make(map[string]interface{})
+func newInternalCache(modelType string, elemType reflect.Type) *internalCache {
+	iq := &internalCache{modelType: modelType, elemType: elemType}
+	iq.shards = newCacheShards()
 	iq.queries = make(map[int64]*internalQuery)
-	iq.UniqueToPrimary = make(map[string]string)
-	iq.PrimaryToUnique = make(map[string]string)
+	iq.viewsMtx = &sync.Mutex{}
+	iq.evicted = make(map[string]bool)
+	iq.expiries = newExpiries()
 	return iq
 }
 
@@ -68,12 +90,69 @@ func addToMetadata(value interface{}, metadataFunc map[string]func(interface{})
 	}
 }
 
+// metadataEntry is a single metadata function result of an element, value is
+// empty when the function does not count values.
+type metadataEntry struct {
+	name  string
+	value string
+}
+
+func metadataEntries(value interface{}, metadataFunc map[string]func(interface{}) (bool, string)) []metadataEntry {
+	entries := make([]metadataEntry, 0, len(metadataFunc))
+	for name, f := range metadataFunc {
+		ok, v := f(value)
+		if ok {
+			entries = append(entries, metadataEntry{name: name, value: v})
+		}
+	}
+	return entries
+}
+
+// applyMetadataEntries adds (delta 1) or retracts (delta -1) an element's metadata
+// entries, removing counters that drop to zero.
+func applyMetadataEntries(entries []metadataEntry, metadata *l8api.L8MetaData, delta float64) {
+	for _, entry := range entries {
+		metadata.KeyCount.Counts[entry.name] += delta
+		if metadata.KeyCount.Counts[entry.name] <= 0 {
+			delete(metadata.KeyCount.Counts, entry.name)
+		}
+		if entry.value == "" {
+			continue
+		}
+		vCount, ok := metadata.ValueCount[entry.name]
+		if !ok {
+			vCount = &l8api.L8Count{}
+			vCount.Counts = make(map[string]float64)
+			metadata.ValueCount[entry.name] = vCount
+		}
+		vCount.Counts[entry.value] += delta
+		if vCount.Counts[entry.value] <= 0 {
+			delete(vCount.Counts, entry.value)
+			if len(vCount.Counts) == 0 {
+				delete(metadata.ValueCount, entry.name)
+			}
+		}
+	}
+}
+
 func (this *internalCache) put(pk, uk string, value interface{}) {
-	_, ok := this.cache[pk]
-	this.cache[pk] = value
+	this.setElement(pk, uk, value)
+	this.recordHistory(pk, value, this.nextRevision(pk))
+}
+
+// setElement adds or replaces an element and updates the keys, indexes and prepared
+// queries, without assigning it a revision.
+func (this *internalCache) setElement(pk, uk string, value interface{}) {
+	old, ok := this.value(pk)
+	oldEntries := this.entriesOf(old, ok)
+	delete(this.evicted, pk)
+	this.setResident(pk, value)
 	this.putUnique(pk, uk)
-	if !ok {
-		this.stamp = time.Now().Unix()
+	this.indexPut(pk, value)
+	this.changed(pk, value, oldEntries)
+	if this.eviction != nil {
+		this.eviction.resident(pk, value)
+		this.evict(pk)
 	}
 }
 
@@ -82,29 +161,192 @@ func (this *internalCache) get(pk, uk string) (interface{}, bool) {
 		return nil, false
 	}
 	if pk == "" && uk != "" {
-		pk = this.UniqueToPrimary[uk]
+		pk = this.primaryOf(uk)
+	}
+	item, ok := this.resident(pk)
+	if this.eviction != nil {
+		if ok {
+			this.eviction.policy.touch(pk)
+		} else {
+			item, ok = this.reload(pk)
+		}
+	}
+	return item, ok
+}
+
+// value returns an element without affecting the eviction order, evicted elements
+// are loaded from the store and are not made resident.
+func (this *internalCache) value(pk string) (interface{}, bool) {
+	item, ok := this.resident(pk)
+	if !ok && len(this.evicted) > 0 {
+		return this.load(pk)
 	}
-	item, ok := this.cache[pk]
 	return item, ok
 }
 
+// forEach iterates all the elements, including the evicted ones.
+func (this *internalCache) forEach(f func(string, interface{})) {
+	this.forEachResident(f)
+	for pk := range this.evicted {
+		if v, ok := this.load(pk); ok {
+			f(pk, v)
+		}
+	}
+}
+
 func (this *internalCache) delete(pk, uk string) (interface{}, bool) {
-	item, ok := this.cache[pk]
+	item, ok := this.removeElement(pk, uk)
+	if ok {
+		this.recordHistory(pk, nil, this.nextRevision(pk))
+		this.setRevision(pk, 0)
+	}
+	return item, ok
+}
+
+// removeElement removes an element and updates the keys, indexes and prepared queries,
+// without recording its deletion.
+func (this *internalCache) removeElement(pk, uk string) (interface{}, bool) {
+	item, ok := this.value(pk)
 	if !ok {
 		return item, ok
 	}
-	delete(this.cache, pk)
+	oldEntries := this.entriesOf(item, ok)
+	this.removeResident(pk)
+	delete(this.evicted, pk)
+	if this.eviction != nil {
+		this.eviction.removed(pk)
+	}
 	this.deleteUnique(pk, uk)
-	this.stamp = time.Now().Unix()
+	this.indexRemove(pk)
+	this.expiries.remove(pk)
+	this.changed(pk, nil, oldEntries)
 	return item, ok
 }
 
-func (this *internalCache) stampChanged() {
-	this.stamp = time.Now().Unix()
+// restore puts back an element as it was before a rolled back change, with its unique
+// key, revision and expiry. Nothing is recorded in the history, and the history of the
+// rolled back changes, those after revision since, is discarded. A nil value restores
+// an element that did not exist, returning false if it still does not exist.
+func (this *internalCache) restore(pk, uk string, value interface{}, revision uint64, expiry int64, since uint64) bool {
+	restored := true
+	if value == nil {
+		_, restored = this.removeElement(pk, "")
+		this.setRevision(pk, 0)
+	} else {
+		if this.uniqueOf(pk) != uk {
+			// drop the unique key the rolled back change may have set
+			this.deleteUnique(pk, "")
+		}
+		this.setElement(pk, uk, value)
+		this.setRevision(pk, revision)
+		this.expiries.set(pk, expiry)
+	}
+	if this.history != nil {
+		this.history.discard(pk, since)
+	}
+	return restored
+}
+
+// patch applies the changes to the cached element and updates the indexes and the
+// prepared queries, returning the patched element. The element is changed in place,
+// or in copy on write mode a new version of it replaces it.
+func (this *internalCache) patch(pk string, item interface{}, changes []*updating.Change, copyOnWrite bool) interface{} {
+	oldEntries := this.entriesOf(item, true)
+	if copyOnWrite {
+		// readers may hold the element
+		item = cloner.Clone(item)
+		for _, change := range changes {
+			change.Apply(item)
+		}
+		this.setResident(pk, item)
+	} else {
+		this.patchResident(pk, func() {
+			for _, change := range changes {
+				change.Apply(item)
+			}
+		})
+	}
+	this.recordHistory(pk, item, this.nextRevision(pk))
+	this.indexPut(pk, item)
+	this.changed(pk, item, oldEntries)
+	if this.eviction != nil {
+		this.eviction.resident(pk, item)
+		this.evict(pk)
+	}
+	return item
+}
+
+// nextRevision assigns the element its next revision from the cache wide counter, so a
+// revision is never reused, not even by an element that is deleted and added again.
+func (this *internalCache) nextRevision(pk string) uint64 {
+	revision := this.revision.Add(1)
+	this.setRevision(pk, revision)
+	return revision
+}
+
+// recordHistory records a copy of the element's new revision when history is kept,
+// value is nil when the element was deleted.
+func (this *internalCache) recordHistory(pk string, value interface{}, revision uint64) {
+	if this.history == nil {
+		return
+	}
+	if value != nil {
+		value = cloner.Clone(value)
+	}
+	this.history.record(pk, value, revision, time.Now().UnixNano())
+}
+
+// entriesOf returns the metadata entries of an element before it is changed,
+// only needed when there are prepared queries to update.
+func (this *internalCache) entriesOf(value interface{}, ok bool) []metadataEntry {
+	if !ok {
+		return nil
+	}
+	this.viewsMtx.Lock()
+	prepared := len(this.queries)
+	this.viewsMtx.Unlock()
+	if prepared == 0 {
+		return nil
+	}
+	return metadataEntries(value, this.metadataFunc)
+}
+
+// changed advances the cache stamp after a single element change. Queries that
+// were up to date are updated incrementally, stale queries are prepared on their
+// next fetch. A query being prepared or paged by a fetch is not waited for, it is
+// left stale, so fetches do not block writes. value is nil when the element was
+// deleted.
+func (this *internalCache) changed(pk string, value interface{}, oldEntries []metadataEntry) {
+	this.viewsMtx.Lock()
+	defer this.viewsMtx.Unlock()
+	this.aggregatesChanged(pk, value)
+	previous := this.stamp
+	this.stamp++
+	var newEntries []metadataEntry
+	if value != nil && len(this.queries) > 0 {
+		newEntries = metadataEntries(value, this.metadataFunc)
+	}
+	for _, dq := range this.queries {
+		if !dq.mtx.TryLock() {
+			continue
+		}
+		if dq.stamp == previous && dq.err == nil {
+			dq.apply(pk, value, oldEntries, newEntries)
+			dq.stamp = this.stamp
+		}
+		dq.mtx.Unlock()
+	}
+}
+
+// currentStamp returns the cache stamp, which changes with every change of an element.
+func (this *internalCache) currentStamp() int64 {
+	this.viewsMtx.Lock()
+	defer this.viewsMtx.Unlock()
+	return this.stamp
 }
 
 func (this *internalCache) size() int {
-	return len(this.cache)
+	return int(this.residents.Load()) + len(this.evicted)
 }
 
 func hashString(s string) int32 {
@@ -115,44 +357,84 @@ func hashString(s string) int32 {
 	return h
 }
 
-func (this *internalCache) fetch(start, blockSize int, q ifs.IQuery, r ifs.IResources) ([]interface{}, *l8api.L8MetaData) {
+// fetch returns a page of the query's elements with their keys, aggregate queries
+// return no elements and their results packed in the metadata.
+func (this *internalCache) fetch(start, blockSize int, q ifs.IQuery, r ifs.IResources) ([]string, []interface{}, *l8api.L8MetaData) {
 	if q.IsAggregate() {
-		return this.fetchAggregate(q)
+		values, metadata := this.fetchAggregate(q, r)
+		return nil, values, metadata
 	}
 
-	aaaId := q.AAAId()
-	hash := int64(q.Hash())
-	if aaaId != "" {
-		hash = hash<<32 | int64(hashString(aaaId))
+	dq := this.prepared(q, r)
+	defer dq.mtx.Unlock()
+	if dq.err != nil {
+		if r != nil {
+			r.Logger().Error("Failed to fetch ", this.modelType, ": ", dq.err.Error())
+		}
+		return nil, []interface{}{}, dq.metadata
 	}
+	keys, values := this.pageWithKeys(dq, start, blockSize)
+	return keys, values, dq.metadata
+}
 
+// prepared returns the cached internal query of q, preparing it if the cache
+// changed since it was last prepared. The query is returned locked, the caller
+// unlocks it once it has read its page.
+func (this *internalCache) prepared(q ifs.IQuery, r ifs.IResources) *internalQuery {
+	aaaId := q.AAAId()
+	hash := preparedKey(q)
+
+	// fetches run concurrently with the cache read lock held
+	this.viewsMtx.Lock()
 	dq, ok := this.queries[hash]
 	if !ok {
 		dq = newInternalQuery(q)
 		this.queries[hash] = dq
 	}
+	this.viewsMtx.Unlock()
 
 	atomic.StoreInt64(&dq.lastUsed, time.Now().Unix())
 
-	if dq.stamp != this.stamp {
-		dq.prepare(this.cache, this.stamp, q.Descending(), this.metadataFunc, r, aaaId)
+	dq.mtx.Lock()
+	// taken before the elements are read, a change made meanwhile leaves the query stale
+	stamp := this.currentStamp()
+	if dq.stamp != stamp {
+		candidates, narrowed := this.candidates(q, r)
+		if !narrowed {
+			candidates = nil
+		}
+		dq.prepare(this, candidates, stamp, q.Descending(), this.metadataFunc, r, aaaId)
 	}
+	return dq
+}
 
-	result := make([]interface{}, 0)
+// preparedKey is the key of a prepared query, the query hash combined with the AAAId
+// and the sort clause, which orders the prepared data.
+func preparedKey(q ifs.IQuery) int64 {
+	scope := q.AAAId() + "\x00" + strings.ToLower(strings.TrimSpace(q.SortBy()))
+	if q.Descending() {
+		scope += "\x00desc"
+	}
+	return int64(q.Hash())<<32 | int64(uint32(hashString(scope)))
+}
+
+// pageWithKeys returns up to blockSize (0 for all) elements of a prepared query starting
+// at start, and their keys.
+func (this *internalCache) pageWithKeys(dq *internalQuery, start, blockSize int) ([]string, []interface{}) {
+	keys := make([]string, 0)
+	values := make([]interface{}, 0)
 	for i := start; i < len(dq.data); i++ {
-		key := dq.data[i]
-		value, ok := this.cache[key]
-		if ok {
-			result = append(result, value)
-		}
-		if blockSize == 0 {
+		value, ok := this.value(dq.data[i])
+		if !ok {
 			continue
 		}
-		if len(result) >= blockSize {
+		keys = append(keys, dq.data[i])
+		values = append(values, value)
+		if blockSize > 0 && len(values) >= blockSize {
 			break
 		}
 	}
-	return result, dq.metadata
+	return keys, values
 }
 
 func (this *internalCache) addMetadataFunc(name string, f func(interface{}) (bool, string)) {
@@ -160,4 +442,8 @@ func (this *internalCache) addMetadataFunc(name string, f func(interface{}) (boo
 		this.metadataFunc = make(map[string]func(interface{}) (bool, string))
 	}
 	this.metadataFunc[name] = f
+	// the prepared queries metadata does not include the new function
+	this.viewsMtx.Lock()
+	this.stamp++
+	this.viewsMtx.Unlock()
 }
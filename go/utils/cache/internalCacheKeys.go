@@ -13,24 +13,59 @@
 
 package cache
 
+// putUnique maps the primary and unique keys of an element, in the shard of each key.
 func (this *internalCache) putUnique(pk, uk string) {
 	if uk == "" {
 		return
 	}
+	shard := this.shardOf(pk)
+	shard.mtx.Lock()
+	oldUk, exists := shard.uniques[pk]
+	shard.uniques[pk] = uk
+	shard.mtx.Unlock()
 	// Clean up old unique key mapping if it exists and is different
-	if oldUk, exists := this.PrimaryToUnique[pk]; exists && oldUk != uk {
-		delete(this.UniqueToPrimary, oldUk)
+	if exists && oldUk != uk {
+		this.removePrimary(oldUk)
 	}
-	this.hasExtraKeys = true
-	this.UniqueToPrimary[uk] = pk
-	this.PrimaryToUnique[pk] = uk
+	shard = this.shardOf(uk)
+	shard.mtx.Lock()
+	shard.primaries[uk] = pk
+	shard.mtx.Unlock()
 }
 
 func (this *internalCache) deleteUnique(pk, uk string) {
+	shard := this.shardOf(pk)
+	shard.mtx.Lock()
 	// If uk not provided, look it up before deleting
 	if uk == "" {
-		uk = this.PrimaryToUnique[pk]
+		uk = shard.uniques[pk]
 	}
-	delete(this.UniqueToPrimary, uk)
-	delete(this.PrimaryToUnique, pk)
+	delete(shard.uniques, pk)
+	shard.mtx.Unlock()
+	if uk != "" {
+		this.removePrimary(uk)
+	}
+}
+
+func (this *internalCache) removePrimary(uk string) {
+	shard := this.shardOf(uk)
+	shard.mtx.Lock()
+	defer shard.mtx.Unlock()
+	delete(shard.primaries, uk)
+}
+
+// primaryOf returns the primary key of a unique key, empty if it is unknown.
+func (this *internalCache) primaryOf(uk string) string {
+	shard := this.shardOf(uk)
+	shard.mtx.RLock()
+	defer shard.mtx.RUnlock()
+	return shard.primaries[uk]
+}
+
+// uniqueOf returns the unique key of a primary key, empty if it has none.
+func (this *internalCache) uniqueOf(pk string) string {
+	shard := this.shardOf(pk)
+	shard.mtx.RLock()
+	defer shard.mtx.RUnlock()
+	return shard.uniques[pk]
 }
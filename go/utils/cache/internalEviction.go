@@ -0,0 +1,354 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+	"reflect"
+
+	"github.com/saichler/l8types/go/ifs"
+)
+
+// evictor tracks the access order of the resident elements and selects the
+// next element to evict, other than the kept one.
+type evictor interface {
+	add(pk string)
+	touch(pk string)
+	remove(pk string)
+	victim(keep string) (string, bool)
+}
+
+type lruEvictor struct {
+	order    *list.List
+	elements map[string]*list.Element
+}
+
+func newLRUEvictor() *lruEvictor {
+	return &lruEvictor{order: list.New(), elements: make(map[string]*list.Element)}
+}
+
+func (this *lruEvictor) add(pk string) {
+	if e, ok := this.elements[pk]; ok {
+		this.order.MoveToFront(e)
+		return
+	}
+	this.elements[pk] = this.order.PushFront(pk)
+}
+
+func (this *lruEvictor) touch(pk string) {
+	if e, ok := this.elements[pk]; ok {
+		this.order.MoveToFront(e)
+	}
+}
+
+func (this *lruEvictor) remove(pk string) {
+	if e, ok := this.elements[pk]; ok {
+		this.order.Remove(e)
+		delete(this.elements, pk)
+	}
+}
+
+func (this *lruEvictor) victim(keep string) (string, bool) {
+	for e := this.order.Back(); e != nil; e = e.Prev() {
+		if pk := e.Value.(string); pk != keep {
+			return pk, true
+		}
+	}
+	return "", false
+}
+
+// lfuEntry is the priority of an element, count is its access count plus the age of
+// the cache when it was last used.
+type lfuEntry struct {
+	pk    string
+	count uint64
+	tick  uint64
+	index int
+}
+
+// lfuHeap orders entries by access count, least recently used first among equal counts.
+type lfuHeap []*lfuEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].tick < h[j].tick
+}
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *lfuHeap) Push(x interface{}) {
+	e := x.(*lfuEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+// lfuEvictor evicts the least frequently used element with dynamic aging: the cache
+// age is the count of the last victim and new and used entries start from it, so new
+// elements are not the next victims of elements that were used a lot long ago.
+type lfuEvictor struct {
+	entries lfuHeap
+	byKey   map[string]*lfuEntry
+	tick    uint64
+	age     uint64
+}
+
+func newLFUEvictor() *lfuEvictor {
+	return &lfuEvictor{entries: make(lfuHeap, 0), byKey: make(map[string]*lfuEntry)}
+}
+
+func (this *lfuEvictor) add(pk string) {
+	if _, ok := this.byKey[pk]; ok {
+		this.touch(pk)
+		return
+	}
+	this.tick++
+	e := &lfuEntry{pk: pk, count: this.age + 1, tick: this.tick}
+	this.byKey[pk] = e
+	heap.Push(&this.entries, e)
+}
+
+func (this *lfuEvictor) touch(pk string) {
+	e, ok := this.byKey[pk]
+	if !ok {
+		return
+	}
+	this.tick++
+	if e.count < this.age {
+		e.count = this.age
+	}
+	e.count++
+	e.tick = this.tick
+	heap.Fix(&this.entries, e.index)
+}
+
+func (this *lfuEvictor) remove(pk string) {
+	e, ok := this.byKey[pk]
+	if !ok {
+		return
+	}
+	heap.Remove(&this.entries, e.index)
+	delete(this.byKey, pk)
+}
+
+// victim returns the least used entry other than keep, which is evicted, and ages the
+// cache to its count.
+func (this *lfuEvictor) victim(keep string) (string, bool) {
+	e := this.leastUsed(keep)
+	if e == nil {
+		return "", false
+	}
+	if e.count > this.age {
+		this.age = e.count
+	}
+	return e.pk, true
+}
+
+func (this *lfuEvictor) leastUsed(keep string) *lfuEntry {
+	if len(this.entries) == 0 {
+		return nil
+	}
+	if this.entries[0].pk != keep {
+		return this.entries[0]
+	}
+	// the kept entry is the root, e.g. it was just added, so the next least used is
+	// one of its children
+	switch len(this.entries) {
+	case 1:
+		return nil
+	case 2:
+		return this.entries[1]
+	}
+	if this.entries.Less(2, 1) {
+		return this.entries[2]
+	}
+	return this.entries[1]
+}
+
+// eviction bounds the resident elements of the internal cache. Evicted elements
+// remain in the store and are loaded back on access.
+type eviction struct {
+	policy     evictor
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	sizes      map[string]int64
+	loader     func(string) (interface{}, bool)
+	// query returns the elements of the store matching the query by primary key, nil
+	// if the store cannot execute it
+	query func(ifs.IQuery) map[string]interface{}
+}
+
+// sizeOf estimates the size of an element in bytes from its exported properties,
+// without encoding it.
+func sizeOf(value interface{}) int64 {
+	return estimateSize(reflect.ValueOf(value), 0)
+}
+
+func estimateSize(v reflect.Value, depth int) int64 {
+	if !v.IsValid() || depth > 32 {
+		return 0
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		return 8 + estimateSize(v.Elem(), depth+1)
+	case reflect.String:
+		return int64(v.Len())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return int64(v.Len())
+		}
+		fallthrough
+	case reflect.Array:
+		size := int64(0)
+		for i := 0; i < v.Len(); i++ {
+			size += estimateSize(v.Index(i), depth+1)
+		}
+		return size
+	case reflect.Map:
+		size := int64(0)
+		iter := v.MapRange()
+		for iter.Next() {
+			size += estimateSize(iter.Key(), depth+1) + estimateSize(iter.Value(), depth+1)
+		}
+		return size
+	case reflect.Struct:
+		size := int64(0)
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).IsExported() {
+				size += estimateSize(v.Field(i), depth+1)
+			}
+		}
+		return size
+	}
+	return int64(v.Type().Size())
+}
+
+// resident registers a new or replaced resident element.
+func (this *eviction) resident(pk string, value interface{}) {
+	if this.maxBytes > 0 {
+		size := sizeOf(value)
+		this.bytes += size - this.sizes[pk]
+		this.sizes[pk] = size
+	}
+	this.policy.add(pk)
+}
+
+func (this *eviction) removed(pk string) {
+	this.bytes -= this.sizes[pk]
+	delete(this.sizes, pk)
+	this.policy.remove(pk)
+}
+
+func (this *eviction) overCapacity(residents int) bool {
+	if this.maxEntries > 0 && residents > this.maxEntries {
+		return true
+	}
+	return this.maxBytes > 0 && this.bytes > this.maxBytes
+}
+
+// evict removes least used elements from the resident map until the cache is within
+// capacity, keeping the given key resident. It stops short of the bound only when
+// the kept element is the last one left.
+func (this *internalCache) evict(keep string) int {
+	if this.eviction == nil {
+		return 0
+	}
+	evicted := 0
+	for this.eviction.overCapacity(int(this.residents.Load())) {
+		pk, ok := this.eviction.policy.victim(keep)
+		if !ok {
+			break
+		}
+		this.eviction.removed(pk)
+		this.removeResident(pk)
+		this.evicted[pk] = true
+		evicted++
+	}
+	return evicted
+}
+
+// forEachQueried iterates the elements a query is evaluated on, the resident elements
+// and the evicted ones matching it, see forEachEvicted.
+func (this *internalCache) forEachQueried(q ifs.IQuery, f func(string, interface{})) {
+	this.forEachResident(f)
+	this.forEachEvicted(q, f)
+}
+
+// forEachEvicted iterates the evicted elements the store returns for the query. They
+// are never loaded one by one, so a query does not bring the store back in memory,
+// and a store that cannot execute the query leaves them out.
+func (this *internalCache) forEachEvicted(q ifs.IQuery, f func(string, interface{})) {
+	if len(this.evicted) == 0 || this.eviction == nil || this.eviction.query == nil {
+		return
+	}
+	for pk, v := range this.eviction.query(q) {
+		if this.evicted[pk] {
+			f(pk, v)
+		}
+	}
+}
+
+// load returns an evicted element from the store.
+func (this *internalCache) load(pk string) (interface{}, bool) {
+	if !this.evicted[pk] || this.eviction == nil || this.eviction.loader == nil {
+		return nil, false
+	}
+	return this.eviction.loader(pk)
+}
+
+// reload makes an evicted element resident again.
+func (this *internalCache) reload(pk string) (interface{}, bool) {
+	item, ok := this.load(pk)
+	if !ok {
+		return nil, false
+	}
+	delete(this.evicted, pk)
+	this.setResident(pk, item)
+	this.eviction.resident(pk, item)
+	this.evict(pk)
+	return item, true
+}
+
+func (this *internalCache) setEviction(e *eviction) {
+	// Bring every element back into the resident map before switching policies
+	for pk := range this.evicted {
+		if item, ok := this.load(pk); ok {
+			this.setResident(pk, item)
+		}
+	}
+	this.evicted = make(map[string]bool)
+	this.eviction = e
+	if e == nil {
+		return
+	}
+	this.forEachResident(e.resident)
+	this.evict("")
+}
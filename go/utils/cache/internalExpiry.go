@@ -0,0 +1,99 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"container/heap"
+	"sync"
+)
+
+type expiryEntry struct {
+	pk string
+	at int64
+}
+
+// expiryHeap orders expiry entries by time, earliest first. Entries are not removed
+// when an element's expiry changes, stale entries are skipped when popped.
+type expiryHeap []expiryEntry
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].at < h[j].at }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(expiryEntry)) }
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// expiries are set by the single key writes of all the shards, mtx guards them.
+type expiries struct {
+	mtx   *sync.Mutex
+	at    map[string]int64
+	queue expiryHeap
+}
+
+func newExpiries() *expiries {
+	return &expiries{mtx: &sync.Mutex{}, at: make(map[string]int64), queue: make(expiryHeap, 0)}
+}
+
+// get returns the expiry time of an element, false if it does not expire.
+func (this *expiries) get(pk string) (int64, bool) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	at, ok := this.at[pk]
+	return at, ok
+}
+
+// set sets the expiry time (unix nano) of an element, zero clears it.
+func (this *expiries) set(pk string, at int64) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	if at <= 0 {
+		delete(this.at, pk)
+		return
+	}
+	this.at[pk] = at
+	heap.Push(&this.queue, expiryEntry{pk: pk, at: at})
+}
+
+func (this *expiries) remove(pk string) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	delete(this.at, pk)
+}
+
+// due returns the keys of the elements that expired at or before now.
+func (this *expiries) due(now int64) []string {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	result := make([]string, 0)
+	for len(this.queue) > 0 && this.queue[0].at <= now {
+		e := heap.Pop(&this.queue).(expiryEntry)
+		if at, ok := this.at[e.pk]; ok && at == e.at {
+			delete(this.at, e.pk)
+			result = append(result, e.pk)
+		}
+	}
+	// Compact when most of the queue is stale entries of re-set expiries
+	if len(this.queue) > 64 && len(this.queue) > 4*len(this.at) {
+		this.queue = make(expiryHeap, 0, len(this.at))
+		for pk, at := range this.at {
+			this.queue = append(this.queue, expiryEntry{pk: pk, at: at})
+		}
+		heap.Init(&this.queue)
+	}
+	return result
+}
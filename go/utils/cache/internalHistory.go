@@ -0,0 +1,132 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// historyEntry is a revision of an element, value is nil when the element was deleted.
+type historyEntry struct {
+	revision uint64
+	stamp    int64
+	value    interface{}
+}
+
+// history keeps the past revisions of every element, oldest first, bounded by a
+// number of revisions and/or an age. It is recorded by the single key writes of all
+// the shards, mtx guards the entries.
+type history struct {
+	mtx          *sync.Mutex
+	maxRevisions int
+	maxAge       time.Duration
+	entries      map[string][]historyEntry
+}
+
+func newHistory(maxRevisions int, maxAge time.Duration) *history {
+	return &history{mtx: &sync.Mutex{}, maxRevisions: maxRevisions, maxAge: maxAge, entries: make(map[string][]historyEntry)}
+}
+
+// record adds a revision of an element, value must already be a private copy.
+func (this *history) record(pk string, value interface{}, revision uint64, now int64) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	entries := append(this.entries[pk], historyEntry{revision: revision, stamp: now, value: value})
+	this.entries[pk] = entries
+	this.prune(pk, now)
+}
+
+// prune drops the revisions beyond the bounds, always keeping the latest one while
+// the element exists so point in time reads after the last change are answered.
+// Without an age bound nothing expires the history of a deleted element, so it is
+// dropped with the element.
+func (this *history) prune(pk string, now int64) {
+	entries := this.entries[pk]
+	if this.maxAge <= 0 && len(entries) > 0 && entries[len(entries)-1].value == nil {
+		delete(this.entries, pk)
+		return
+	}
+	drop := 0
+	if this.maxRevisions > 0 && len(entries) > this.maxRevisions {
+		drop = len(entries) - this.maxRevisions
+	}
+	if this.maxAge > 0 {
+		oldest := now - int64(this.maxAge)
+		for drop < len(entries)-1 && entries[drop+1].stamp <= oldest {
+			// entries[drop] was replaced before the horizon
+			drop++
+		}
+		if drop == len(entries)-1 && entries[drop].value == nil && entries[drop].stamp <= oldest {
+			drop++
+		}
+	}
+	if drop == 0 {
+		return
+	}
+	if drop >= len(entries) {
+		delete(this.entries, pk)
+		return
+	}
+	this.entries[pk] = append([]historyEntry(nil), entries[drop:]...)
+}
+
+// discard drops the revisions of an element after the given one, e.g. of a rolled back batch.
+func (this *history) discard(pk string, since uint64) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	entries := this.entries[pk]
+	keep := len(entries)
+	for keep > 0 && entries[keep-1].revision > since {
+		keep--
+	}
+	if keep == len(entries) {
+		return
+	}
+	if keep == 0 {
+		delete(this.entries, pk)
+		return
+	}
+	this.entries[pk] = entries[:keep]
+}
+
+func (this *history) pruneAll(now int64) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	for pk := range this.entries {
+		this.prune(pk, now)
+	}
+}
+
+// asOf returns the revision of an element that was current at the given time.
+func (this *history) asOf(pk string, stamp int64) (historyEntry, bool) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	entries := this.entries[pk]
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].stamp > stamp
+	})
+	if i == 0 {
+		return historyEntry{}, false
+	}
+	return entries[i-1], true
+}
+
+// revisionsOf returns the kept revisions of an element, oldest first.
+func (this *history) revisionsOf(pk string) []historyEntry {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	return append([]historyEntry(nil), this.entries[pk]...)
+}
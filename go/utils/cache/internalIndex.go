@@ -0,0 +1,404 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/saichler/l8types/go/ifs"
+)
+
+const nilIndexKey = "<nil>"
+
+// criteriaQuery is implemented by queries that expose their parsed WHERE clause.
+type criteriaQuery interface {
+	Criteria() ifs.IExpression
+}
+
+type numericKey struct {
+	number float64
+	key    string
+}
+
+// internalIndex is a secondary index over a single property path. Values are
+// normalized into string keys (strings are lower cased, numbers are formatted)
+// so that a lookup always yields a superset of the elements the query matches,
+// the query itself is still evaluated on every candidate. Every shard indexes its
+// own elements.
+type internalIndex struct {
+	property *propertyPath
+	values   map[string]map[string]bool
+	keyOf    map[string]string
+	numbers  map[string]float64
+	ordered  []numericKey
+}
+
+func newInternalIndex(property *propertyPath) *internalIndex {
+	idx := &internalIndex{property: property}
+	idx.values = make(map[string]map[string]bool)
+	idx.keyOf = make(map[string]string)
+	idx.numbers = make(map[string]float64)
+	idx.ordered = make([]numericKey, 0)
+	return idx
+}
+
+// indexKeyOf returns the normalized index key of a value and, for numeric kinds,
+// its float64 value for range lookups.
+func indexKeyOf(value interface{}, ok bool) (string, float64, bool) {
+	if !ok || value == nil {
+		return nilIndexKey, 0, false
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String:
+		return strings.ToLower(v.String()), 0, false
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), 0, false
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		// formatted at the field's precision, so a float32 1.1 is keyed "1.1"
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits()), v.Float(), true
+	}
+	return strings.ToLower(fmt.Sprintf("%v", value)), 0, false
+}
+
+func (this *internalIndex) put(pk string, value interface{}) {
+	key, number, isNumber := indexKeyOf(this.property.valueOf(value))
+	oldKey, exists := this.keyOf[pk]
+	if exists && oldKey == key {
+		return
+	}
+	if exists {
+		this.remove(pk)
+	}
+	pks, ok := this.values[key]
+	if !ok {
+		pks = make(map[string]bool)
+		this.values[key] = pks
+		if isNumber {
+			this.numbers[key] = number
+			this.insertOrdered(number, key)
+		}
+	}
+	pks[pk] = true
+	this.keyOf[pk] = key
+}
+
+func (this *internalIndex) remove(pk string) {
+	key, ok := this.keyOf[pk]
+	if !ok {
+		return
+	}
+	delete(this.keyOf, pk)
+	pks := this.values[key]
+	delete(pks, pk)
+	if len(pks) > 0 {
+		return
+	}
+	delete(this.values, key)
+	if number, isNumber := this.numbers[key]; isNumber {
+		delete(this.numbers, key)
+		this.removeOrdered(number, key)
+	}
+}
+
+func (this *internalIndex) insertOrdered(number float64, key string) {
+	i := sort.Search(len(this.ordered), func(i int) bool {
+		return this.ordered[i].number >= number
+	})
+	this.ordered = append(this.ordered, numericKey{})
+	copy(this.ordered[i+1:], this.ordered[i:])
+	this.ordered[i] = numericKey{number: number, key: key}
+}
+
+func (this *internalIndex) removeOrdered(number float64, key string) {
+	i := sort.Search(len(this.ordered), func(i int) bool {
+		return this.ordered[i].number >= number
+	})
+	for ; i < len(this.ordered) && this.ordered[i].number == number; i++ {
+		if this.ordered[i].key == key {
+			this.ordered = append(this.ordered[:i], this.ordered[i+1:]...)
+			return
+		}
+	}
+}
+
+// lookup returns the primary keys that may satisfy the comparator, false if the
+// comparator cannot be answered by this index.
+func (this *internalIndex) lookup(operator, right string) (map[string]bool, bool) {
+	right = strings.TrimSpace(right)
+	operator = strings.ToLower(strings.TrimSpace(operator))
+	switch operator {
+	case "=", "==":
+		key, ok := this.keyForLiteral(right)
+		if !ok {
+			return nil, false
+		}
+		return this.collect([]string{key}), true
+	case "in":
+		list := strings.Trim(right, "[]() ")
+		keys := make([]string, 0)
+		for _, item := range strings.Split(list, ",") {
+			key, ok := this.keyForLiteral(item)
+			if !ok {
+				return nil, false
+			}
+			keys = append(keys, key)
+		}
+		return this.collect(keys), true
+	case ">", ">=", "<", "<=":
+		return this.lookupRange(operator, right)
+	}
+	return nil, false
+}
+
+func (this *internalIndex) keyForLiteral(literal string) (string, bool) {
+	literal = strings.Trim(strings.TrimSpace(literal), "'\"")
+	if literal == "" || strings.Contains(literal, "*") {
+		return "", false
+	}
+	lower := strings.ToLower(literal)
+	if lower == "nil" || lower == "null" {
+		return "", false
+	}
+	if !this.isNumeric() {
+		return lower, true
+	}
+	if i, err := strconv.ParseInt(literal, 10, 64); err == nil {
+		return strconv.FormatInt(i, 10), true
+	}
+	bits := this.floatBits()
+	if f, err := strconv.ParseFloat(literal, bits); err == nil {
+		return strconv.FormatFloat(f, 'f', -1, bits), true
+	}
+	// e.g. an enum compared by name, the index can't answer it
+	return "", false
+}
+
+func (this *internalIndex) isNumeric() bool {
+	return isNumericKind(this.property.kind())
+}
+
+// floatBits is the precision literals are parsed at, matching the indexed values.
+func (this *internalIndex) floatBits() int {
+	if this.property.kind() == reflect.Float32 {
+		return 32
+	}
+	return 64
+}
+
+func (this *internalIndex) lookupRange(operator, right string) (map[string]bool, bool) {
+	if !this.isNumeric() {
+		return nil, false
+	}
+	bound, err := strconv.ParseFloat(strings.Trim(right, "'\""), this.floatBits())
+	if err != nil {
+		return nil, false
+	}
+	from, to := 0, len(this.ordered)
+	switch operator {
+	case ">":
+		from = sort.Search(len(this.ordered), func(i int) bool { return this.ordered[i].number > bound })
+	case ">=":
+		from = sort.Search(len(this.ordered), func(i int) bool { return this.ordered[i].number >= bound })
+	case "<":
+		to = sort.Search(len(this.ordered), func(i int) bool { return this.ordered[i].number >= bound })
+	case "<=":
+		to = sort.Search(len(this.ordered), func(i int) bool { return this.ordered[i].number > bound })
+	}
+	keys := make([]string, 0, to-from)
+	for i := from; i < to; i++ {
+		keys = append(keys, this.ordered[i].key)
+	}
+	return this.collect(keys), true
+}
+
+func (this *internalIndex) collect(keys []string) map[string]bool {
+	result := make(map[string]bool)
+	for _, key := range keys {
+		for pk := range this.values[key] {
+			result[pk] = true
+		}
+	}
+	return result
+}
+
+// candidates narrows the cache down to the primary keys that may match the query's
+// WHERE clause using the declared indexes. Returns false when no narrowing is possible
+// and the whole cache must be scanned.
+func (this *internalCache) candidates(q ifs.IQuery, r ifs.IResources) (map[string]bool, bool) {
+	if len(this.indexes) == 0 {
+		return nil, false
+	}
+	cq, ok := q.(criteriaQuery)
+	if !ok {
+		// logged once, the indexes are never used for such queries
+		if r != nil && this.indexBypassLogged.CompareAndSwap(false, true) {
+			r.Logger().Warning("Query type ", reflect.TypeOf(q).String(),
+				" does not expose its criteria, ", this.modelType, " indexes are not used")
+		}
+		return nil, false
+	}
+	keys, narrowed := this.narrowExpression(cq.Criteria(), this.modelType)
+	if narrowed {
+		this.indexedFetches.Add(1)
+	}
+	return keys, narrowed
+}
+
+func (this *internalCache) narrowExpression(expr ifs.IExpression, modelType string) (map[string]bool, bool) {
+	if expr == nil {
+		return nil, false
+	}
+	parts := make([]map[string]bool, 0, 3)
+	narrowedParts := 0
+	total := 0
+	if expr.Condition() != nil {
+		total++
+		if keys, ok := this.narrowCondition(expr.Condition(), modelType); ok {
+			parts = append(parts, keys)
+			narrowedParts++
+		}
+	}
+	if expr.Child() != nil {
+		total++
+		if keys, ok := this.narrowExpression(expr.Child(), modelType); ok {
+			parts = append(parts, keys)
+			narrowedParts++
+		}
+	}
+	if expr.Next() != nil {
+		total++
+		if keys, ok := this.narrowExpression(expr.Next(), modelType); ok {
+			parts = append(parts, keys)
+			narrowedParts++
+		}
+	}
+	return combine(parts, expr.Operator() == "or", narrowedParts == total)
+}
+
+func (this *internalCache) narrowCondition(cond ifs.ICondition, modelType string) (map[string]bool, bool) {
+	if cond == nil {
+		return nil, false
+	}
+	parts := make([]map[string]bool, 0, 2)
+	narrowedParts := 0
+	total := 1
+	if keys, ok := this.narrowComparator(cond.Comparator(), modelType); ok {
+		parts = append(parts, keys)
+		narrowedParts++
+	}
+	if cond.Next() != nil {
+		total++
+		if keys, ok := this.narrowCondition(cond.Next(), modelType); ok {
+			parts = append(parts, keys)
+			narrowedParts++
+		}
+	}
+	return combine(parts, cond.Operator() == "or", narrowedParts == total)
+}
+
+func (this *internalCache) narrowComparator(comp ifs.IComparator, modelType string) (map[string]bool, bool) {
+	if comp == nil {
+		return nil, false
+	}
+	name := normalizePropertyName(comp.Left(), modelType)
+	if _, ok := this.indexes[name]; !ok {
+		return nil, false
+	}
+	// every shard indexes its own elements
+	result := make(map[string]bool)
+	for _, shard := range this.shards {
+		shard.mtx.RLock()
+		keys, ok := shard.indexes[name].lookup(comp.Operator(), comp.Right())
+		shard.mtx.RUnlock()
+		if !ok {
+			return nil, false
+		}
+		for pk := range keys {
+			result[pk] = true
+		}
+	}
+	return result, true
+}
+
+// combine intersects (and) or unions (or) the narrowed parts of an expression.
+// An "or" can only be narrowed when all of its parts were narrowed.
+func combine(parts []map[string]bool, isOr, all bool) (map[string]bool, bool) {
+	if len(parts) == 0 {
+		return nil, false
+	}
+	if isOr {
+		if !all {
+			return nil, false
+		}
+		result := make(map[string]bool)
+		for _, part := range parts {
+			for pk := range part {
+				result[pk] = true
+			}
+		}
+		return result, true
+	}
+	result := parts[0]
+	for _, part := range parts[1:] {
+		intersection := make(map[string]bool)
+		for pk := range result {
+			if part[pk] {
+				intersection[pk] = true
+			}
+		}
+		result = intersection
+	}
+	return result, true
+}
+
+func (this *internalCache) addIndex(property *propertyPath) {
+	name := strings.ToLower(property.path)
+	for _, shard := range this.shards {
+		shard.mtx.Lock()
+		shard.indexes[name] = newInternalIndex(property)
+		shard.mtx.Unlock()
+	}
+	this.forEach(this.indexPut)
+	if this.indexes == nil {
+		this.indexes = make(map[string]*propertyPath)
+	}
+	this.indexes[name] = property
+}
+
+func (this *internalCache) indexPut(pk string, value interface{}) {
+	shard := this.shardOf(pk)
+	shard.mtx.Lock()
+	defer shard.mtx.Unlock()
+	for _, idx := range shard.indexes {
+		idx.put(pk, value)
+	}
+}
+
+func (this *internalCache) indexRemove(pk string) {
+	shard := this.shardOf(pk)
+	shard.mtx.Lock()
+	defer shard.mtx.Unlock()
+	for _, idx := range shard.indexes {
+		idx.remove(pk)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"net"
 	"reflect"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/saichler/l8types/go/ifs"
@@ -25,61 +26,165 @@ import (
 )
 
 type internalQuery struct {
-	query    ifs.IQuery
-	data     []string
-	stamp    int64
-	hash     int64
-	metadata *l8api.L8MetaData
-	lastUsed int64
+	mtx        *sync.Mutex
+	query      ifs.IQuery
+	data       []string
+	members    map[string][]interface{}
+	stamp      int64
+	hash       int64
+	metadata   *l8api.L8MetaData
+	lastUsed   int64
+	descending bool
+	sortKeys   []*sortKey
+	r          ifs.IResources
+	aaaId      string
+	// err is set when the query can't be prepared, e.g. an unknown sort key
+	err error
 }
 
 func newInternalQuery(query ifs.IQuery) *internalQuery {
-	iq := &internalQuery{query: query}
+	iq := &internalQuery{query: query, mtx: &sync.Mutex{}}
 	iq.hash = int64(query.Hash())
+	iq.members = make(map[string][]interface{})
 	iq.metadata = newMetadata()
 	iq.lastUsed = time.Now().Unix()
 	return iq
 }
 
-func (this *internalQuery) prepare(cache map[string]interface{}, stamp int64, descending bool, metadataFunc map[string]func(interface{}) (bool, string), r ifs.IResources, aaaId string) {
+// prepare evaluates the query over the cache, or only over the candidate keys when
+// the indexes were able to narrow them, and sorts the matching keys.
+func (this *internalQuery) prepare(cache *internalCache, candidates map[string]bool, stamp int64, descending bool, metadataFunc map[string]func(interface{}) (bool, string), r ifs.IResources, aaaId string) {
 	this.stamp = stamp
 	this.metadata = newMetadata()
-
-	data := make([]string, 0)
-	hasScopeFilter := r != nil && r.Security() != nil && aaaId != ""
-	uuid := ""
-	if r != nil && r.SysConfig() != nil {
-		uuid = r.SysConfig().LocalUuid
+	this.descending = descending
+	this.r = r
+	this.aaaId = aaaId
+	this.sortKeys, this.err = parseSortKeys(this.query, descending, cache.elemType, cache.modelType)
+	if this.err != nil {
+		this.data = nil
+		this.members = make(map[string][]interface{})
+		return
 	}
 
-	for k, v := range cache {
-		if !this.query.Match(v) {
-			continue
-		}
-		if hasScopeFilter {
-			if r.Security().ScopeItem(r, v, uuid, aaaId) == nil {
-				continue
-			}
+	matched := make([]keyValue, 0)
+	members := make(map[string][]interface{})
+
+	matchAndAdd := func(k string, v interface{}) {
+		if !this.matches(v) {
+			return
 		}
-		data = append(data, k)
+		sortValues := this.sortValues(v)
+		matched = append(matched, keyValue{key: k, sortValues: sortValues})
+		members[k] = sortValues
 		addToMetadata(v, metadataFunc, this.metadata)
 	}
 
-	sort.Slice(data, func(i, j int) bool {
-		if this.query.SortBy() != "" {
-			v1 := this.query.SortByValue(cache[data[i]])
-			v2 := this.query.SortByValue(cache[data[j]])
-			if v1 != nil && v2 != nil {
-				result := lessThan(v1, v2)
-				if descending {
-					return !result
-				}
-				return result
+	if candidates != nil {
+		for k := range candidates {
+			if v, ok := cache.resident(k); ok {
+				matchAndAdd(k, v)
 			}
 		}
-		return lessThan(data[i], data[j])
+		cache.forEachEvicted(this.query, matchAndAdd)
+	} else {
+		cache.forEachQueried(this.query, matchAndAdd)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return this.lessBySortValues(matched[i].key, matched[i].sortValues, matched[j].key, matched[j].sortValues)
 	})
+	data := make([]string, len(matched))
+	for i, kv := range matched {
+		data[i] = kv.key
+	}
 	this.data = data
+	this.members = members
+}
+
+type keyValue struct {
+	key        string
+	sortValues []interface{}
+}
+
+// matches returns true if the element matches the query and is in the security
+// scope of the query's AAAId.
+func (this *internalQuery) matches(v interface{}) bool {
+	return this.query.Match(v) && inScope(this.r, v, this.aaaId)
+}
+
+// inScope returns true if the element is in the security scope of the AAAId.
+func inScope(r ifs.IResources, v interface{}, aaaId string) bool {
+	if r != nil && r.Security() != nil && aaaId != "" {
+		uuid := ""
+		if r.SysConfig() != nil {
+			uuid = r.SysConfig().LocalUuid
+		}
+		if r.Security().ScopeItem(r, v, uuid, aaaId) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// sortValues returns the values an element is sorted by, nil when the query is not sorted.
+func (this *internalQuery) sortValues(v interface{}) []interface{} {
+	return sortValues(this.sortKeys, this.query, v)
+}
+
+// lessBySortValues orders two elements by their values of the query sort keys, with the
+// primary key as a tiebreaker so the order is total and stable between prepares.
+func (this *internalQuery) lessBySortValues(k1 string, s1 []interface{}, k2 string, s2 []interface{}) bool {
+	if c := compareSortValues(this.sortKeys, s1, s2); c != 0 {
+		return c < 0
+	}
+	return lessThan(k1, k2)
+}
+
+// after returns the position of the first key ordered after the given sort values
+// and key, which need not be in the query anymore.
+func (this *internalQuery) after(pk string, sortValues []interface{}) int {
+	return sort.Search(len(this.data), func(i int) bool {
+		return this.lessBySortValues(pk, sortValues, this.data[i], this.members[this.data[i]])
+	})
+}
+
+// apply updates a prepared query with a single element change instead of preparing
+// it again. value is nil when the element was deleted, oldEntries are the metadata
+// entries of the element before the change.
+func (this *internalQuery) apply(pk string, value interface{}, oldEntries, newEntries []metadataEntry) {
+	if sortValues, ok := this.members[pk]; ok {
+		this.removeKey(pk, sortValues)
+		applyMetadataEntries(oldEntries, this.metadata, -1)
+	}
+	if value != nil && this.matches(value) {
+		this.insertKey(pk, this.sortValues(value))
+		applyMetadataEntries(newEntries, this.metadata, 1)
+	}
+}
+
+// position returns the position of the member with the given sort values, the keys
+// being ordered by the sort values the members had when they were added.
+func (this *internalQuery) position(pk string, sortValues []interface{}) int {
+	return sort.Search(len(this.data), func(i int) bool {
+		return !this.lessBySortValues(this.data[i], this.members[this.data[i]], pk, sortValues)
+	})
+}
+
+// removeKey removes a member, sortValues are the sort values it was added with.
+func (this *internalQuery) removeKey(pk string, sortValues []interface{}) {
+	i := this.position(pk, sortValues)
+	if i < len(this.data) && this.data[i] == pk {
+		this.data = append(this.data[:i], this.data[i+1:]...)
+	}
+	delete(this.members, pk)
+}
+
+func (this *internalQuery) insertKey(pk string, sortValues []interface{}) {
+	i := this.position(pk, sortValues)
+	this.data = append(this.data, "")
+	copy(this.data[i+1:], this.data[i:])
+	this.data[i] = pk
+	this.members[pk] = sortValues
 }
 
 func lessThan(a interface{}, b interface{}) bool {
@@ -135,6 +240,10 @@ func lessThan(a interface{}, b interface{}) bool {
 		if v2, ok := b.(uint32); ok {
 			return v1 < v2
 		}
+	case bool:
+		if v2, ok := b.(bool); ok {
+			return !v1 && v2
+		}
 	}
 
 	// Handle custom types (like protobuf enums) using reflection
@@ -155,6 +264,8 @@ func lessThan(a interface{}, b interface{}) bool {
 		return va.Float() < vb.Float()
 	case reflect.String:
 		return va.String() < vb.String()
+	case reflect.Bool:
+		return !va.Bool() && vb.Bool()
 	}
 
 	return false
@@ -0,0 +1,150 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import "sync"
+
+// cacheShards is the number of shards of the resident elements, a power of 2.
+const cacheShards = 64
+
+// cacheShard holds the resident elements of a range of primary keys, with their unique
+// keys, revisions and index entries, and the primary keys of a range of unique keys.
+// They are only changed with the shard lock held, so they are read with it, and reads
+// of one shard are not blocked by writes of the others. writer serializes the single
+// key writes of the shard, which run with the cache read lock, so writes of keys of
+// different shards run in parallel. The shard lock is never held while another one is
+// taken.
+type cacheShard struct {
+	mtx       *sync.RWMutex
+	writer    *sync.Mutex
+	items     map[string]interface{}
+	uniques   map[string]string
+	primaries map[string]string
+	revisions map[string]uint64
+	indexes   map[string]*internalIndex
+}
+
+func newCacheShards() []*cacheShard {
+	shards := make([]*cacheShard, cacheShards)
+	for i := range shards {
+		shards[i] = &cacheShard{mtx: &sync.RWMutex{}, writer: &sync.Mutex{}}
+		shards[i].items = make(map[string]interface{})
+		shards[i].uniques = make(map[string]string)
+		shards[i].primaries = make(map[string]string)
+		shards[i].revisions = make(map[string]uint64)
+		shards[i].indexes = make(map[string]*internalIndex)
+	}
+	return shards
+}
+
+// shardOf returns the shard of a primary key, or of a unique key for its primary key.
+func (this *internalCache) shardOf(key string) *cacheShard {
+	return this.shards[uint32(hashString(key))&(cacheShards-1)]
+}
+
+// resident returns a resident element.
+func (this *internalCache) resident(pk string) (interface{}, bool) {
+	shard := this.shardOf(pk)
+	shard.mtx.RLock()
+	defer shard.mtx.RUnlock()
+	item, ok := shard.items[pk]
+	return item, ok
+}
+
+// setResident adds or replaces a resident element.
+func (this *internalCache) setResident(pk string, value interface{}) {
+	shard := this.shardOf(pk)
+	shard.mtx.Lock()
+	defer shard.mtx.Unlock()
+	if _, ok := shard.items[pk]; !ok {
+		this.residents.Add(1)
+	}
+	shard.items[pk] = value
+}
+
+// removeResident removes a resident element.
+func (this *internalCache) removeResident(pk string) {
+	shard := this.shardOf(pk)
+	shard.mtx.Lock()
+	defer shard.mtx.Unlock()
+	if _, ok := shard.items[pk]; ok {
+		this.residents.Add(-1)
+		delete(shard.items, pk)
+	}
+}
+
+// patchResident changes a resident element in place.
+func (this *internalCache) patchResident(pk string, patch func()) {
+	shard := this.shardOf(pk)
+	shard.mtx.Lock()
+	defer shard.mtx.Unlock()
+	patch()
+}
+
+// forEachResident iterates the resident elements, shard by shard. f is called without
+// the shard lock, so it may read the cache.
+func (this *internalCache) forEachResident(f func(string, interface{})) {
+	for _, shard := range this.shards {
+		shard.mtx.RLock()
+		pks := make([]string, 0, len(shard.items))
+		values := make([]interface{}, 0, len(shard.items))
+		for pk, v := range shard.items {
+			pks = append(pks, pk)
+			values = append(values, v)
+		}
+		shard.mtx.RUnlock()
+		for i, pk := range pks {
+			f(pk, values[i])
+		}
+	}
+}
+
+// residentCopy returns a resident element, by primary or unique key, without the cache
+// lock. The element itself is returned if shared, a copy of it otherwise.
+func (this *internalCache) residentCopy(pk, uk string, shared bool) (interface{}, bool) {
+	if pk == "" {
+		if uk == "" {
+			return nil, false
+		}
+		pk = this.primaryOf(uk)
+	}
+	shard := this.shardOf(pk)
+	shard.mtx.RLock()
+	defer shard.mtx.RUnlock()
+	item, ok := shard.items[pk]
+	if !ok || shared {
+		return item, ok
+	}
+	return cloner.Clone(item), true
+}
+
+// revisionOf returns the revision of an element, 0 if it does not exist.
+func (this *internalCache) revisionOf(pk string) uint64 {
+	shard := this.shardOf(pk)
+	shard.mtx.RLock()
+	defer shard.mtx.RUnlock()
+	return shard.revisions[pk]
+}
+
+// setRevision sets the revision of an element, 0 removes it.
+func (this *internalCache) setRevision(pk string, revision uint64) {
+	shard := this.shardOf(pk)
+	shard.mtx.Lock()
+	defer shard.mtx.Unlock()
+	if revision == 0 {
+		delete(shard.revisions, pk)
+		return
+	}
+	shard.revisions[pk] = revision
+}
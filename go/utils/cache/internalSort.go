@@ -0,0 +1,183 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/saichler/l8types/go/ifs"
+)
+
+// sortKey is one column of a query's sort order.
+type sortKey struct {
+	// property is nil when the key could not be resolved on the model type, the
+	// value is then taken from the query's SortByValue.
+	property   *propertyPath
+	descending bool
+	nullsFirst bool
+}
+
+// parseSortKeys parses a sort clause of comma separated keys, each a property path
+// optionally followed by asc/desc and nulls first/last, e.g. "severity desc, time asc".
+// Keys without a direction use the query's direction, nulls are last unless specified.
+// A single key that is not a property of the model type is sorted by the query's
+// SortByValue, with several keys every one of them must be a property.
+func parseSortKeys(query ifs.IQuery, descending bool, elemType reflect.Type, modelType string) ([]*sortKey, error) {
+	sortBy := strings.TrimSpace(query.SortBy())
+	if sortBy == "" {
+		return nil, nil
+	}
+	parts := strings.Split(sortBy, ",")
+	keys := make([]*sortKey, 0, len(parts))
+	for _, part := range parts {
+		name, key := parseSortKey(part, descending)
+		if key == nil {
+			continue
+		}
+		property, err := newPropertyPath(elemType, normalizePropertyName(name, modelType))
+		if err == nil {
+			key.property = property
+		} else if len(parts) > 1 {
+			return nil, errors.New("Unknown sort key " + name + " of " + modelType)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// parseRowSortKeys parses a sort clause of rows that are not elements, returning the
+// keys and the column names they sort by.
+func parseRowSortKeys(sortBy string, descending bool) ([]*sortKey, []string) {
+	if strings.TrimSpace(sortBy) == "" {
+		return nil, nil
+	}
+	var keys []*sortKey
+	var columns []string
+	for _, part := range strings.Split(sortBy, ",") {
+		name, key := parseSortKey(part, descending)
+		if key == nil {
+			continue
+		}
+		keys = append(keys, key)
+		columns = append(columns, name)
+	}
+	return keys, columns
+}
+
+// parseSortKey parses one key of a sort clause, returning its name and nil if it is empty.
+func parseSortKey(part string, descending bool) (string, *sortKey) {
+	tokens := strings.Fields(part)
+	if len(tokens) == 0 {
+		return "", nil
+	}
+	key := &sortKey{descending: descending}
+	for i := 1; i < len(tokens); i++ {
+		switch strings.ToLower(tokens[i]) {
+		case "asc", "ascending":
+			key.descending = false
+		case "desc", "descending":
+			key.descending = true
+		case "nulls":
+			if i+1 < len(tokens) {
+				key.nullsFirst = strings.EqualFold(tokens[i+1], "first")
+				i++
+			}
+		}
+	}
+	return tokens[0], key
+}
+
+// sortValues returns the values an element is sorted by, one per sort key.
+func sortValues(keys []*sortKey, query ifs.IQuery, v interface{}) []interface{} {
+	if len(keys) == 0 || v == nil {
+		return nil
+	}
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		if key.property == nil {
+			values[i] = sortable(query.SortByValue(v))
+			continue
+		}
+		if value, ok := key.property.valueOf(v); ok {
+			values[i] = sortable(value)
+		}
+	}
+	return values
+}
+
+// compareSortValues orders two rows of sort values, returning -1, 0 or 1.
+func compareSortValues(keys []*sortKey, s1, s2 []interface{}) int {
+	if len(s1) != len(keys) || len(s2) != len(keys) {
+		return 0
+	}
+	for i, key := range keys {
+		a, b := s1[i], s2[i]
+		if a == nil && b == nil {
+			continue
+		}
+		if a == nil || b == nil {
+			if (a == nil) == key.nullsFirst {
+				return -1
+			}
+			return 1
+		}
+		if lessThan(a, b) {
+			if key.descending {
+				return 1
+			}
+			return -1
+		}
+		if lessThan(b, a) {
+			if key.descending {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// sortable converts a property value to a value lessThan can compare. Timestamps,
+// time.Time or a struct with Seconds and Nanos fields, become unix nanoseconds and
+// nil pointers become nil.
+func sortable(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	if t, ok := value.(time.Time); ok {
+		return t.UnixNano()
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return value
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.UnixNano()
+	}
+	seconds := v.FieldByName("Seconds")
+	nanos := v.FieldByName("Nanos")
+	if seconds.IsValid() && nanos.IsValid() && seconds.CanInt() && nanos.CanInt() {
+		return seconds.Int()*int64(time.Second) + nanos.Int()
+	}
+	return value
+}
@@ -0,0 +1,343 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// walHeaderSize is the size of a record header, the data length and its crc32.
+const walHeaderSize = 8
+
+// wal is an append-only log of segment files named <prefix>-<segment>.wal in a
+// directory. Each record is framed as length, crc32 and data.
+type wal struct {
+	dir          string
+	prefix       string
+	policy       WALSyncPolicy
+	syncInterval time.Duration
+	segmentSize  int64
+
+	mtx     sync.Mutex
+	file    *os.File
+	segment uint64
+	size    int64
+	dirty   bool
+	stop    chan struct{}
+
+	// compactSegments and compactSize are the thresholds of sinceSegments and sinceSize,
+	// the segments started and bytes written since the last compaction, at which
+	// compactions is signaled
+	compactSegments int
+	compactSize     int64
+	sinceSegments   int
+	sinceSize       int64
+	compactions     chan struct{}
+}
+
+func openWAL(config *WALConfig, prefix string) (*wal, error) {
+	err := os.MkdirAll(config.Dir, 0755)
+	if err != nil {
+		return nil, errors.New("Failed to create WAL directory: " + err.Error())
+	}
+	w := &wal{dir: config.Dir, prefix: prefix, policy: config.SyncPolicy,
+		syncInterval: config.SyncInterval, segmentSize: config.SegmentSize,
+		compactSegments: config.CompactSegments, compactSize: config.CompactSize}
+	if w.compactSegments == 0 {
+		w.compactSegments = defaultWALCompactSegments
+	}
+	if w.syncInterval <= 0 {
+		w.syncInterval = time.Second
+	}
+	if w.segmentSize <= 0 {
+		w.segmentSize = defaultWALSegmentSize
+	}
+	return w, nil
+}
+
+func (this *wal) segmentPath(segment uint64) string {
+	return filepath.Join(this.dir, fmt.Sprintf("%s-%016d.wal", this.prefix, segment))
+}
+
+// segments returns the numbers of the existing segments in order.
+func (this *wal) segments() ([]uint64, error) {
+	entries, err := os.ReadDir(this.dir)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]uint64, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, this.prefix+"-") || !strings.HasSuffix(name, ".wal") {
+			continue
+		}
+		segment, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, this.prefix+"-"), ".wal"), 10, 64)
+		if err != nil {
+			continue
+		}
+		result = append(result, segment)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result, nil
+}
+
+// replay calls apply with the data of every record in order. A torn or corrupted
+// record at the end of the last segment, from a crash in the middle of a write,
+// is truncated. Anywhere else it fails the replay.
+func (this *wal) replay(apply func([]byte) error) error {
+	segments, err := this.segments()
+	if err != nil {
+		return err
+	}
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		err = this.replaySegment(segment, last, apply)
+		if err != nil {
+			return err
+		}
+		this.segment = segment
+		this.sinceSegments++
+	}
+	return nil
+}
+
+func (this *wal) replaySegment(segment uint64, last bool, apply func([]byte) error) error {
+	path := this.segmentPath(segment)
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	offset := int64(0)
+	header := make([]byte, walHeaderSize)
+	for {
+		_, err = io.ReadFull(file, header)
+		if err == io.EOF {
+			this.sinceSize += offset
+			return nil
+		}
+		var data []byte
+		if err == nil {
+			size := binary.BigEndian.Uint32(header)
+			if size > maxSnapshotField {
+				err = errors.New("record size exceeds the limit")
+			} else {
+				data = make([]byte, size)
+				_, err = io.ReadFull(file, data)
+			}
+			if err == nil && crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:]) {
+				err = errors.New("record checksum mismatch")
+			}
+		}
+		if err != nil {
+			if !last {
+				return errors.New("Corrupted WAL segment " + path + " at offset " + strconv.FormatInt(offset, 10) + ": " + err.Error())
+			}
+			this.sinceSize += offset
+			return os.Truncate(path, offset)
+		}
+		err = apply(data)
+		if err != nil {
+			return errors.New("Failed to replay WAL segment " + path + " at offset " + strconv.FormatInt(offset, 10) + ": " + err.Error())
+		}
+		offset += int64(walHeaderSize + len(data))
+	}
+}
+
+// start opens a new segment after the replayed ones and starts the interval sync.
+func (this *wal) start() error {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	err := this.rotate()
+	if err != nil {
+		return err
+	}
+	this.stop = make(chan struct{})
+	if this.policy == WALSyncInterval {
+		go this.syncLoop(this.stop)
+	}
+	return nil
+}
+
+// compactLoop calls compact whenever the log grew past one of its compaction
+// thresholds, until the log is closed. It must be called after start.
+func (this *wal) compactLoop(compact func()) {
+	this.mtx.Lock()
+	stop := this.stop
+	this.compactions = make(chan struct{}, 1)
+	compactions := this.compactions
+	this.mtx.Unlock()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-compactions:
+			compact()
+		}
+	}
+}
+
+// compacted restarts counting the growth of the log after a compaction, dropping a
+// compaction signaled while the log was rewritten.
+func (this *wal) compacted() {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	this.sinceSegments = 0
+	this.sinceSize = 0
+	select {
+	case <-this.compactions:
+	default:
+	}
+}
+
+// signalCompaction signals compactions, without blocking, if the log grew past one
+// of its thresholds, must be called with mtx held.
+func (this *wal) signalCompaction() {
+	if this.compactions == nil {
+		return
+	}
+	if (this.compactSegments > 0 && this.sinceSegments >= this.compactSegments) ||
+		(this.compactSize > 0 && this.sinceSize >= this.compactSize) {
+		select {
+		case this.compactions <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// rotate closes the current segment and opens the next one, must be called with mtx held.
+func (this *wal) rotate() error {
+	if this.file != nil {
+		err := this.closeFile()
+		if err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(this.segmentPath(this.segment+1), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.New("Failed to open WAL segment: " + err.Error())
+	}
+	this.segment++
+	this.sinceSegments++
+	this.file = file
+	this.size = 0
+	return nil
+}
+
+func (this *wal) closeFile() error {
+	err := this.file.Sync()
+	if err == nil {
+		err = this.file.Close()
+	}
+	this.file = nil
+	this.dirty = false
+	return err
+}
+
+// append writes a record, rotating the segment when it is full.
+func (this *wal) append(data []byte) error {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	if this.file == nil {
+		return errors.New("WAL is closed")
+	}
+	if this.size > 0 && this.size+int64(walHeaderSize+len(data)) > this.segmentSize {
+		err := this.rotate()
+		if err != nil {
+			return err
+		}
+	}
+	record := make([]byte, walHeaderSize+len(data))
+	binary.BigEndian.PutUint32(record, uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:], crc32.ChecksumIEEE(data))
+	copy(record[walHeaderSize:], data)
+	_, err := this.file.Write(record)
+	if err != nil {
+		return errors.New("Failed to write WAL record: " + err.Error())
+	}
+	this.size += int64(len(record))
+	this.sinceSize += int64(len(record))
+	this.signalCompaction()
+	if this.policy == WALSyncAlways {
+		return this.file.Sync()
+	}
+	this.dirty = true
+	return nil
+}
+
+func (this *wal) sync() error {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	if this.file == nil || !this.dirty {
+		return nil
+	}
+	this.dirty = false
+	return this.file.Sync()
+}
+
+func (this *wal) syncLoop(stop chan struct{}) {
+	ticker := time.NewTicker(this.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			this.sync()
+		}
+	}
+}
+
+// removeBefore deletes the segments older than the given one.
+func (this *wal) removeBefore(segment uint64) error {
+	segments, err := this.segments()
+	if err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if s >= segment {
+			break
+		}
+		err = os.Remove(this.segmentPath(s))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *wal) close() error {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	if this.stop != nil {
+		close(this.stop)
+		this.stop = nil
+	}
+	if this.file == nil {
+		return nil
+	}
+	return this.closeFile()
+}
@@ -19,15 +19,26 @@ import (
 	"github.com/saichler/l8utils/go/utils/notify"
 )
 
+// nextSequence takes the sequence of the next delta notification, writes of different
+// shards create their notifications in parallel.
+func (this *Cache) nextSequence() uint32 {
+	return this.notifySequence.Add(1) - 1
+}
+
 func (this *Cache) createNotificationSet(t l8notify.L8NotificationType, key string, changeCount int) *l8notify.L8NotificationSet {
-	defer func() { this.notifySequence++ }()
-	return notify.CreateNotificationSet(t, this.serviceName, key, this.serviceArea, this.modelType, this.Source(), changeCount, this.notifySequence)
+	return notify.CreateNotificationSet(t, this.serviceName, key, this.serviceArea, this.modelType, this.Source(), changeCount, this.nextSequence())
 }
 
-func (this *Cache) createClientNotification(delta *l8notify.L8NotificationSet) *l8notify.L8NotificationSet {
+// createClientNotification creates the notification of the subscribers of a change,
+// affected are the AAAIds of the watches the change affected.
+func (this *Cache) createClientNotification(delta *l8notify.L8NotificationSet, affected map[string]bool) *l8notify.L8NotificationSet {
 	if delta == nil || !this.HasSubscribers() {
 		return nil
 	}
+	aaaIds := this.subscriberAaaIds(affected)
+	if len(aaaIds) == 0 {
+		return nil
+	}
 	cn := &l8notify.L8NotificationSet{}
 	cn.ServiceName = delta.ServiceName
 	cn.ServiceArea = delta.ServiceArea
@@ -36,51 +47,59 @@ func (this *Cache) createClientNotification(delta *l8notify.L8NotificationSet) *
 	cn.Type = delta.Type
 	cn.Source = delta.Source
 	cn.NotificationList = delta.NotificationList
-	cn.AaaIds = this.subscriberAaaIds()
+	cn.AaaIds = aaaIds
 	return cn
 }
 
-func (this *Cache) createClientNotificationForPatch(item interface{}, key string) *l8notify.L8NotificationSet {
+// createClientNotificationForPatch creates the notification of the subscribers of a
+// patch, with sequence, the sequence of its delta notification.
+func (this *Cache) createClientNotificationForPatch(item interface{}, key string, affected map[string]bool, sequence uint32) *l8notify.L8NotificationSet {
 	if !this.HasSubscribers() {
 		return nil
 	}
-	n, e := this.createAddNotification(item, key)
+	aaaIds := this.subscriberAaaIds(affected)
+	if len(aaaIds) == 0 {
+		return nil
+	}
+	// the client notification shares the sequence of the delta, so the delta stream of
+	// the cache has no gaps
+	n, e := notify.CreateAddNotification(item, this.serviceName, key, this.serviceArea, this.modelType, this.Source(), 1, sequence)
 	if e != nil {
 		return nil
 	}
 	n.Type = l8notify.L8NotificationType_Patch
-	n.AaaIds = this.subscriberAaaIds()
+	n.AaaIds = aaaIds
 	return n
 }
 
-func (this *Cache) subscriberAaaIds() map[string]bool {
+// subscriberAaaIds returns the AAAIds to notify of a change, every subscription that
+// is not a watch and the watches in affected.
+func (this *Cache) subscriberAaaIds(affected map[string]bool) map[string]bool {
 	subs := this.Subscribers()
 	if len(subs) == 0 {
 		return nil
 	}
 	ids := make(map[string]bool, len(subs))
 	for _, s := range subs {
-		ids[s.AAAId] = true
+		if s.query == nil || affected[s.AAAId] {
+			ids[s.AAAId] = true
+		}
 	}
 	return ids
 }
 
 func (this *Cache) createAddNotification(any interface{}, key string) (*l8notify.L8NotificationSet, error) {
-	defer func() { this.notifySequence++ }()
-	return notify.CreateAddNotification(any, this.serviceName, key, this.serviceArea, this.modelType, this.Source(), 1, this.notifySequence)
+	return notify.CreateAddNotification(any, this.serviceName, key, this.serviceArea, this.modelType, this.Source(), 1, this.nextSequence())
 }
 
 func (this *Cache) createReplaceNotification(old, new interface{}, key string) (*l8notify.L8NotificationSet, error) {
-	defer func() { this.notifySequence++ }()
-	return notify.CreateReplaceNotification(old, new, this.serviceName, key, this.serviceArea, this.modelType, this.Source(), 1, this.notifySequence)
+	return notify.CreateReplaceNotification(old, new, this.serviceName, key, this.serviceArea, this.modelType, this.Source(), 1, this.nextSequence())
 }
 
 func (this *Cache) createDeleteNotification(any interface{}, key string) (*l8notify.L8NotificationSet, error) {
-	defer func() { this.notifySequence++ }()
-	return notify.CreateDeleteNotification(any, this.serviceName, key, this.serviceArea, this.modelType, this.Source(), 1, this.notifySequence)
+	return notify.CreateDeleteNotification(any, this.serviceName, key, this.serviceArea, this.modelType, this.Source(), 1, this.nextSequence())
 }
 
 func (this *Cache) createUpdateNotification(changes []*updating.Change, key string) (*l8notify.L8NotificationSet, error) {
-	defer func() { this.notifySequence++ }()
-	return notify.CreateUpdateNotification(changes, this.serviceName, key, this.serviceArea, this.modelType, this.Source(), len(changes), this.notifySequence)
+	return notify.CreateUpdateNotification(changes, this.serviceName, key, this.serviceArea, this.modelType, this.Source(), len(changes), this.nextSequence())
 }
@@ -0,0 +1,108 @@
+// © 2025 Sharon Aicler (saichler@gmail.com)
+//
+// Layer 8 Ecosystem is licensed under the Apache License, Version 2.0.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// propertyPath is a pre-resolved, dot separated path of struct fields (e.g. "Info.Status")
+// on the cached model type. Field names are matched case-insensitively so the same path
+// can be used with the names that appear in queries.
+type propertyPath struct {
+	path     string
+	steps    [][]int
+	leafKind reflect.Kind
+}
+
+func newPropertyPath(t reflect.Type, path string) (*propertyPath, error) {
+	if t == nil {
+		return nil, errors.New("Cannot resolve property path " + path + " without a model type")
+	}
+	pp := &propertyPath{path: path}
+	for _, name := range strings.Split(path, ".") {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return nil, errors.New("Property path " + path + " does not resolve to a struct field at " + name)
+		}
+		field, ok := t.FieldByNameFunc(func(n string) bool {
+			return strings.EqualFold(n, name)
+		})
+		if !ok || field.PkgPath != "" {
+			return nil, errors.New("Property path " + path + " has no field " + name)
+		}
+		pp.steps = append(pp.steps, field.Index)
+		t = field.Type
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	pp.leafKind = t.Kind()
+	return pp, nil
+}
+
+// valueOf returns the value at the path for the given element, false if a nil
+// pointer was encountered on the way.
+func (this *propertyPath) valueOf(any interface{}) (interface{}, bool) {
+	v := reflect.ValueOf(any)
+	for _, step := range this.steps {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return nil, false
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return nil, false
+		}
+		v = v.FieldByIndex(step)
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, false
+		}
+		if v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Struct {
+			break
+		}
+		v = v.Elem()
+	}
+	return v.Interface(), true
+}
+
+// kind returns the kind of the value at the end of the path.
+func (this *propertyPath) kind() reflect.Kind {
+	return this.leafKind
+}
+
+// normalizePropertyName lowers the given query property name and strips a leading
+// model type prefix, so "MyString", "mystring" and "testproto.mystring" are equal.
+func normalizePropertyName(name, modelType string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	prefix := strings.ToLower(modelType) + "."
+	return strings.TrimPrefix(name, prefix)
+}
+
+func isNumericKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}